@@ -0,0 +1,149 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// defaultMaxBatchSize is the largest batch CreateCarsBatch accepts unless CARDEMO_MAX_BATCH_SIZE says otherwise
+const defaultMaxBatchSize = 100
+
+// maxBatchSizeEnv names the environment variable holding the largest batch CreateCarsBatch accepts
+const maxBatchSizeEnv = "CARDEMO_MAX_BATCH_SIZE"
+
+// eventCarsCreated is emitted once for a whole batch, since a transaction keeps only its last event
+const eventCarsCreated = "CarsCreated"
+
+// BatchResult is the outcome of one entry of CreateCarsBatch
+type BatchResult struct {
+	Index  int    `json:"index"`
+	CarId  string `json:"carId"`
+	Status string `json:"status"`
+}
+
+// loadMaxBatchSize reads the maximum batch size from the environment, falling back to the default
+func loadMaxBatchSize() (int, error) {
+	raw := strings.TrimSpace(os.Getenv(maxBatchSizeEnv))
+	if raw == "" {
+		return defaultMaxBatchSize, nil
+	}
+
+	size, err := strconv.Atoi(raw)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("%s must be a positive number, got %q", maxBatchSizeEnv, raw)
+	}
+
+	return size, nil
+}
+
+// batchLimit returns the configured maximum batch size, or the default when none was configured
+func (s *CarChainCode) batchLimit() int {
+	if s.maxBatchSize <= 0 {
+		return defaultMaxBatchSize
+	}
+
+	return s.maxBatchSize
+}
+
+// transientBatchPrices reads the JSON array of prices passed in the transient map, one per batch entry
+func transientBatchPrices(ctx contractapi.TransactionContextInterface, count int) ([]CarPrices, error) {
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read transient map. %s", err.Error())
+	}
+
+	pricesAsBytes, ok := transientMap[transientPricesKey]
+	if !ok {
+		return nil, fmt.Errorf("Prices must be passed in the transient map under %q", transientPricesKey)
+	}
+
+	prices := []CarPrices{}
+	if err := json.Unmarshal(pricesAsBytes, &prices); err != nil {
+		return nil, fmt.Errorf("Failed to decode transient batch prices. %s", err.Error())
+	}
+
+	if len(prices) != count {
+		return nil, fmt.Errorf("Batch of %d cars needs %d prices, got %d", count, count, len(prices))
+	}
+
+	return prices, nil
+}
+
+// CreateCarsBatch creates every car in the batch, or none of them. Every entry is validated before anything is
+// written, and the error lists each entry that failed. The transient map carries a JSON array of prices, one per entry.
+func (s *CarChainCode) CreateCarsBatch(ctx contractapi.TransactionContextInterface, inputs []CarInput) ([]BatchResult, error) {
+	if err := s.requireRole(ctx, roleManufacturer); err != nil {
+		return nil, err
+	}
+
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("Batch must contain at least one car")
+	}
+
+	if len(inputs) > s.batchLimit() {
+		return nil, fmt.Errorf("Batch of %d cars exceeds the maximum of %d", len(inputs), s.batchLimit())
+	}
+
+	prices, err := transientBatchPrices(ctx, len(inputs))
+	if err != nil {
+		return nil, err
+	}
+
+	cars := make([]*Car, len(inputs))
+	failures := []string{}
+	seen := map[string]int{}
+	for i, input := range inputs {
+		car, err := newCar(ctx, input)
+		if err == nil && prices[i].Salt == "" {
+			err = fmt.Errorf("Prices must carry a salt")
+		}
+		if err == nil && prices[i].ManufacturerPrice < 0 {
+			err = fmt.Errorf("Prices cannot be negative")
+		}
+		// the batch's own writes are not visible to GetState, so duplicates within it are caught here
+		if err == nil {
+			if first, ok := seen[car.CarId]; ok {
+				err = fmt.Errorf("Car %s is also entry %d of the batch", car.CarId, first)
+			} else {
+				seen[car.CarId] = i
+			}
+		}
+
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("[%d] %s: %s", i, input.CarId, err.Error()))
+			continue
+		}
+		cars[i] = car
+	}
+
+	if len(failures) > 0 {
+		return nil, fmt.Errorf("Batch rejected, no car was created. %s", strings.Join(failures, "; "))
+	}
+
+	results := []BatchResult{}
+	carIds := []string{}
+	for i, car := range cars {
+		if err := s.storeNewCar(ctx, car, &prices[i]); err != nil {
+			return nil, fmt.Errorf("Batch rejected at entry %d. %s", i, err.Error())
+		}
+
+		results = append(results, BatchResult{Index: i, CarId: car.CarId, Status: car.Status})
+		carIds = append(carIds, car.CarId)
+	}
+
+	event := CarEvent{EventName: eventCarsCreated, Status: statusCreated, TxId: ctx.GetStub().GetTxID(), CarIds: carIds}
+	eventAsBytes, _ := json.Marshal(event)
+	if err := ctx.GetStub().SetEvent(eventCarsCreated, eventAsBytes); err != nil {
+		return nil, fmt.Errorf("Failed to set %s event. %s", eventCarsCreated, err.Error())
+	}
+
+	return results, nil
+}
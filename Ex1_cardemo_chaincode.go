@@ -6,91 +6,273 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"strconv"
 	"time"
 
+	"github.com/hyperledger/fabric-chaincode-go/shim"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
 
 // Smart Contract Chaincode provides functions to manage a Car manufacturer to an owner delivary
 type CarChainCode struct {
 	contractapi.Contract
+
+	// roleRules maps submitter identities onto roles; nil means defaultRoleRules
+	roleRules []RoleRule
+
+	// maxBatchSize caps the number of cars CreateCarsBatch accepts; 0 means defaultMaxBatchSize
+	maxBatchSize int
 }
 
 /*
  Car structure to record the world state
 */
 type Car struct {
+	DocType                    string      `json:"docType"`
+	SchemaVersion              int         `json:"schemaVersion"`
+	ManufacturerId             string      `json:"manufacturerId"`
+	CarId                      string      `json:"carId"`
+	Vin                        string      `json:"vin,omitempty" metadata:",optional"`
+	DealerId                   string      `json:"dealerId"`
+	ConsumerId                 string      `json:"consumerId"`
+	CarMake                    string      `json:"carMake"`
+	CarModel                   string      `json:"carModel"`
+	CarColor                   string      `json:"carColor"`
+	Status                     string      `json:"status"`
+	ManufacturingDate          string      `json:"manufacturingDate"`
+	ShippingDate               string      `json:"shippingDate"`
+	DeliveryDate               string      `json:"deliveryDate"`
+	SoldOnDate                 string      `json:"soldOnDate"`
+	ShipmentId                 string      `json:"shipmentId,omitempty" metadata:",optional"`
+	CarrierId                  string      `json:"carrierId,omitempty" metadata:",optional"`
+	Owners                     []Ownership `json:"owners,omitempty" metadata:",optional"`
+	OpenRecalls                []string    `json:"openRecalls,omitempty" metadata:",optional"`
+	ScrappedOnDate             string      `json:"scrappedOnDate,omitempty" metadata:",optional"`
+	DestructionCertificateHash string      `json:"destructionCertificateHash,omitempty" metadata:",optional"`
+	Odometer                   int         `json:"odometer" metadata:",optional"`
+	OdometerRollback           bool        `json:"odometerRollback,omitempty" metadata:",optional"`
+	LienId                     string      `json:"lienId,omitempty" metadata:",optional"`
+	LienHolderId               string      `json:"lienHolderId,omitempty" metadata:",optional"`
+}
+
+// QueryResult structure used for handling result of query
+type QueryResult struct {
+	Key    string `json:"Key"`
+	Record *Car
+}
+
+// PaginatedQueryResult structure used for returning one page of query results
+type PaginatedQueryResult struct {
+	Records             []QueryResult `json:"records"`
+	FetchedRecordsCount int32         `json:"fetchedRecordsCount"`
+	Bookmark            string        `json:"bookmark"`
+}
+
+// CarHistoryEntry is one version of a Car record taken from the ledger's key history
+type CarHistoryEntry struct {
+	TxId      string `json:"txId"`
+	Timestamp string `json:"timestamp"`
+	IsDelete  bool   `json:"isDelete"`
+	Record    *Car   `json:"record"`
+}
+
+// CarInput is the input of CreateCar
+type CarInput struct {
 	ManufacturerId    string `json:"manufacturerId"`
 	CarId             string `json:"carId"`
-	DealerId          string `json:"dealerId"`
-	ConsumerId        string `json:"consumerId"`
 	CarMake           string `json:"carMake"`
 	CarModel          string `json:"carModel"`
 	CarColor          string `json:"carColor"`
-	Status            string `json:"status"`
 	ManufacturingDate string `json:"manufacturingDate"`
-	ShippingDate      string `json:"shippingDate"`
-	DeliveryDate      string `json:"deliveryDate"`
-	SoldOnDate        string `json:"soldOnDate"`
-	ManufacturerPrice int    `json:"manufacturerPrice"`
-	ShippingPrice     int    `json:"shippingPrice"`
-	CustomerPrice     int    `json:"customerPrice"`
 }
 
-// QueryResult structure used for handling result of query
-type QueryResult struct {
-	Key    string `json:"Key"`
-	Record *Car
-}
+// CreateCar adds a new car to the world state with given details and fails if the car already exists.
+// The manufacturer price is passed in the transient map.
+// A 17 character carId is a VIN: it must pass the check digit and match the manufacturer's WMI and the model year in carMake.
+func (s *CarChainCode) CreateCar(ctx contractapi.TransactionContextInterface, input CarInput) (*Car, error) {
 
-// InitLedger adds a base set of cars to the ledger
-func (s *CarChainCode) InitLedger(ctx contractapi.TransactionContextInterface) error {
-	cars := []Car{
-		Car{ManufacturerId: "MOrg01", CarId: "M101", DealerId: "D101", ConsumerId: "CUST101", CarMake: "2022", CarModel: "MOrg01CM101", CarColor: "Red", Status: "SOLD", ManufacturingDate: "2022/01/01", ShippingDate: "2022/02/01", DeliveryDate: "2022/02/20", SoldOnDate: "2022/04/20", ManufacturerPrice: 350000, ShippingPrice: 10000, CustomerPrice: 550000},
-		Car{ManufacturerId: "MOrg01", CarId: "M102", DealerId: "D102", ConsumerId: "CUST102", CarMake: "2022", CarModel: "MOrg01CM102", CarColor: "Blue", Status: "SOLD", ManufacturingDate: "2022/01/01", ShippingDate: "2022/02/01", DeliveryDate: "2022/02/20", SoldOnDate: "2022/04/20", ManufacturerPrice: 360000, ShippingPrice: 10000, CustomerPrice: 600000},
-		Car{ManufacturerId: "MOrg02", CarId: "M103", DealerId: "D102", ConsumerId: "CUST103", CarMake: "2022", CarModel: "MOrg02CM103", CarColor: "Blue", Status: "SOLD", ManufacturingDate: "2022/01/01", ShippingDate: "2022/02/01", DeliveryDate: "2022/02/20", SoldOnDate: "2022/04/20", ManufacturerPrice: 360000, ShippingPrice: 10000, CustomerPrice: 630000},
-		Car{ManufacturerId: "MOrg02", CarId: "M104", DealerId: "D101", ConsumerId: "CUST101", CarMake: "2022", CarModel: "MOrg01CM101", CarColor: "Red", Status: "SOLD", ManufacturingDate: "2022/01/01", ShippingDate: "2022/02/01", DeliveryDate: "2022/02/20", SoldOnDate: "2022/04/20", ManufacturerPrice: 350000, ShippingPrice: 10000, CustomerPrice: 550000},
+	if err := s.requireRole(ctx, roleManufacturer); err != nil {
+		return nil, err
+	}
+	car, err := newCar(ctx, input)
+	if err != nil {
+		return nil, err
 	}
 
-	for i, car := range cars {
-		carAsBytes, _ := json.Marshal(car)
-		err := ctx.GetStub().PutState("CAR"+strconv.Itoa(i), carAsBytes)
+	prices, err := transientPrices(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-		if err != nil {
-			return fmt.Errorf("Failed Car data to put to world state. %s", err.Error())
+	if err := s.storeNewCar(ctx, car, prices); err != nil {
+		return nil, err
+	}
+
+	return car, nil
+}
+
+// newCar validates the input and returns the CREATED car it describes, failing if the car already exists
+func newCar(ctx contractapi.TransactionContextInterface, input CarInput) (*Car, error) {
+	if input.CarId == "" {
+		return nil, fmt.Errorf("Car id must be set")
+	}
+	if err := requireActiveParticipant(ctx, roleManufacturer, input.ManufacturerId); err != nil {
+		return nil, err
+	}
+	manufacturerId, err := registeredSubmitter(ctx, roleManufacturer)
+	if err != nil {
+		return nil, err
+	}
+	if manufacturerId != input.ManufacturerId {
+		return nil, fmt.Errorf("Manufacturer %s cannot create cars for %s", manufacturerId, input.ManufacturerId)
+	}
+	if err := validateDate("manufacturingDate", input.ManufacturingDate); err != nil {
+		return nil, err
+	}
+	car := Car{
+		ManufacturerId: input.ManufacturerId,
+		CarId:          input.CarId,
+		CarMake:        input.CarMake,
+		CarModel:       input.CarModel,
+		CarColor:       input.CarColor,
+
+		ManufacturingDate: input.ManufacturingDate,
+	}
+	if isVin(car.CarId) {
+		if err := checkVin(ctx, &car); err != nil {
+			return nil, err
 		}
 	}
 
-	return nil
+	key, err := carKey(ctx, car.CarId)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("Car %s already exists", car.CarId)
+	}
+
+	if err := transition(&car, statusCreated); err != nil {
+		return nil, err
+	}
+
+	return &car, nil
 }
 
-// CreateCar adds a new car to the world state with given details
-func (s *CarChainCode) createNewCar(ctx contractapi.TransactionContextInterface, manufacturerId string, carId string, carMake string, carModel string, carColor string, manufacturingDate string, manufacturerPrice int, role string) error {
+// storeNewCar writes a car built by newCar and its private manufacturer price
+func (s *CarChainCode) storeNewCar(ctx contractapi.TransactionContextInterface, car *Car, prices *CarPrices) error {
+	price := ManufacturerDealerPrice{CarId: car.CarId, ManufacturerPrice: prices.ManufacturerPrice, Salt: prices.Salt}
+	if err := putPrivate(ctx, manufacturerDealerCollection, car.CarId, price); err != nil {
+		return err
+	}
 
-	if role != "manufacturer" {
-		return fmt.Errorf("Failed to put new Car to world state due to unauthorized user")
+	return s.putCar(ctx, car.CarId, car)
+}
+
+// carKey returns the world state key of the car with the given id
+func carKey(ctx contractapi.TransactionContextInterface, carId string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(carKeyType, []string{carId})
+	if err != nil {
+		return "", fmt.Errorf("Failed to create car key. %s", err.Error())
+	}
+
+	return key, nil
+}
+
+// carIdOf returns the car id of a world state key, leaving keys that are not composite unchanged
+func carIdOf(ctx contractapi.TransactionContextInterface, key string) string {
+	objectType, attributes, err := ctx.GetStub().SplitCompositeKey(key)
+	if err != nil || objectType != carKeyType || len(attributes) != 1 {
+		return key
 	}
-	car := Car{
-		ManufacturerId: manufacturerId,
-		CarId:          carId,
-		CarMake:        carMake,
-		CarModel:       carModel,
-		CarColor:       carColor,
-		Status:         "CREATED",
 
-		ManufacturingDate: manufacturingDate,
-		ManufacturerPrice: manufacturerPrice,
+	return attributes[0]
+}
+
+// putCar writes the car at currentSchemaVersion under the key of the given car id, keeps its field indexes in step and emits its life cycle event
+func (s *CarChainCode) putCar(ctx contractapi.TransactionContextInterface, carId string, car *Car) error {
+	car.DocType = carDocType
+	if _, err := upgradeCar(car); err != nil {
+		return err
+	}
+
+	key, err := carKey(ctx, carId)
+	if err != nil {
+		return err
+	}
+
+	previousAsBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	var previous *Car
+	previousStatus := statusNone
+	if previousAsBytes != nil {
+		previous = new(Car)
+		_ = json.Unmarshal(previousAsBytes, previous)
+		previousStatus = previous.Status
+	}
+
+	if err := updateCarIndexes(ctx, carId, previous, car); err != nil {
+		return err
 	}
 
 	carAsBytes, _ := json.Marshal(car)
 
-	return ctx.GetStub().PutState(carId, carAsBytes)
+	if err := ctx.GetStub().PutState(key, carAsBytes); err != nil {
+		return err
+	}
+
+	return emitCarEvent(ctx, carId, previousStatus, car)
+}
+
+// getAsset reads the JSON asset stored under the composite key, returning false if it does not exist
+func getAsset(ctx contractapi.TransactionContextInterface, objectType string, attributes []string, asset interface{}) (bool, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return false, fmt.Errorf("Failed to create %s key. %s", objectType, err.Error())
+	}
+
+	assetAsBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return false, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if assetAsBytes == nil {
+		return false, nil
+	}
+
+	if err := json.Unmarshal(assetAsBytes, asset); err != nil {
+		return false, fmt.Errorf("Failed to decode %s. %s", objectType, err.Error())
+	}
+
+	return true, nil
+}
+
+// putAsset writes the asset as JSON under the composite key
+func putAsset(ctx contractapi.TransactionContextInterface, objectType string, attributes []string, asset interface{}) error {
+	key, err := ctx.GetStub().CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return fmt.Errorf("Failed to create %s key. %s", objectType, err.Error())
+	}
+
+	assetAsBytes, _ := json.Marshal(asset)
+
+	return ctx.GetStub().PutState(key, assetAsBytes)
 }
 
 // QueryCar returns the car stored in the world state with given id
 func (s *CarChainCode) QueryCar(ctx contractapi.TransactionContextInterface, carNumber string) (*Car, error) {
-	carAsBytes, err := ctx.GetStub().GetState(carNumber)
+	key, err := carKey(ctx, carNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	carAsBytes, err := ctx.GetStub().GetState(key)
 
 	if err != nil {
 		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
@@ -100,24 +282,58 @@ func (s *CarChainCode) QueryCar(ctx contractapi.TransactionContextInterface, car
 		return nil, fmt.Errorf("%s does not exist", carNumber)
 	}
 
-	car := new(Car)
-	_ = json.Unmarshal(carAsBytes, car)
+	return unmarshalCar(carAsBytes)
+}
 
-	return car, nil
+// QueryAllCars returns all cars found in world state, leaving out scrapped cars unless includeScrapped is set
+func (s *CarChainCode) QueryAllCars(ctx contractapi.TransactionContextInterface, includeScrapped bool) ([]QueryResult, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(carKeyType, []string{})
+
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	results, err := collectQueryResults(ctx, resultsIterator)
+	if err != nil {
+		return nil, err
+	}
+
+	if includeScrapped {
+		return results, nil
+	}
+
+	return withoutScrapped(results), nil
 }
 
-// QueryAllCars returns all cars found in world state
-func (s *CarChainCode) QueryAllCars(ctx contractapi.TransactionContextInterface) ([]QueryResult, error) {
-	startKey := ""
-	endKey := ""
+// QueryAllCarsWithPagination returns one page of at most pageSize cars, starting after the given bookmark.
+// Scrapped cars are left out unless includeScrapped is set, so a page may hold fewer than pageSize cars.
+func (s *CarChainCode) QueryAllCarsWithPagination(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string, includeScrapped bool) (*PaginatedQueryResult, error) {
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("Page size must be positive, got %d", pageSize)
+	}
 
-	resultsIterator, err := ctx.GetStub().GetStateByRange(startKey, endKey)
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(carKeyType, []string{}, pageSize, bookmark)
 
 	if err != nil {
 		return nil, err
 	}
 	defer resultsIterator.Close()
 
+	results, err := collectQueryResults(ctx, resultsIterator)
+	if err != nil {
+		return nil, err
+	}
+
+	if !includeScrapped {
+		results = withoutScrapped(results)
+	}
+
+	return &PaginatedQueryResult{Records: results, FetchedRecordsCount: metadata.FetchedRecordsCount, Bookmark: metadata.Bookmark}, nil
+}
+
+// collectQueryResults reads every car from a state query iterator, keying each result by car id
+func collectQueryResults(ctx contractapi.TransactionContextInterface, resultsIterator shim.StateQueryIteratorInterface) ([]QueryResult, error) {
 	results := []QueryResult{}
 
 	for resultsIterator.HasNext() {
@@ -127,74 +343,239 @@ func (s *CarChainCode) QueryAllCars(ctx contractapi.TransactionContextInterface)
 			return nil, err
 		}
 
-		car := new(Car)
-		_ = json.Unmarshal(queryResponse.Value, car)
+		car, err := unmarshalCar(queryResponse.Value)
+		if err != nil {
+			return nil, err
+		}
 
-		queryResult := QueryResult{Key: queryResponse.Key, Record: car}
+		queryResult := QueryResult{Key: carIdOf(ctx, queryResponse.Key), Record: car}
 		results = append(results, queryResult)
 	}
 
 	return results, nil
 }
 
-// Manufecturer ship the car to dealer. This method updates the shipment details for given carId in world state
-func (s *CarChainCode) ShipToDealer(ctx contractapi.TransactionContextInterface, carId string, dealerId string, shippingPrice int, role string) error {
+// GetCarHistory returns every version of the car stored under the given id, oldest first
+func (s *CarChainCode) GetCarHistory(ctx contractapi.TransactionContextInterface, carId string) ([]CarHistoryEntry, error) {
+	key, err := carKey(ctx, carId)
+	if err != nil {
+		return nil, err
+	}
+
+	historyIterator, err := ctx.GetStub().GetHistoryForKey(key)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read history of %s. %s", carId, err.Error())
+	}
+	defer historyIterator.Close()
+
+	history := []CarHistoryEntry{}
+
+	for historyIterator.HasNext() {
+		modification, err := historyIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		entry := CarHistoryEntry{TxId: modification.TxId, IsDelete: modification.IsDelete}
+		if modification.Timestamp != nil {
+			entry.Timestamp = time.Unix(modification.Timestamp.Seconds, int64(modification.Timestamp.Nanos)).UTC().Format(time.RFC3339Nano)
+		}
+
+		if !modification.IsDelete {
+			car := new(Car)
+			if err := json.Unmarshal(modification.Value, car); err != nil {
+				return nil, fmt.Errorf("Failed to decode %s at tx %s. %s", carId, modification.TxId, err.Error())
+			}
+			entry.Record = car
+		}
+
+		history = append(history, entry)
+	}
+
+	if len(history) == 0 {
+		return nil, fmt.Errorf("%s does not exist", carId)
+	}
+
+	return history, nil
+}
+
+// Manufecturer ship the car to dealer. This method updates the shipment details for given carId in world state,
+// starts a new shipment handed to the given carrier to track
+// and records the shipping price, passed in the transient map, in the manufacturer-dealer collection.
+// The dealer pays the manufacturer and shipping prices in settlement tokens out of its allowance for the manufacturer,
+// and the payment is reversed if the car is rejected, lost or returned.
+// Every life cycle transaction records the car's odometer reading, see recordOdometer.
+func (s *CarChainCode) ShipToDealer(ctx contractapi.TransactionContextInterface, carId string, dealerId string, carrierId string, odometer int) error {
 
-	if role != "manufacturer" {
-		return fmt.Errorf("Failed to put to world state due to unauthorized user")
+	if err := s.requireRole(ctx, roleManufacturer); err != nil {
+		return err
+	}
+	if carrierId == "" {
+		return fmt.Errorf("Carrier id must be set")
 	}
 	car, err := s.QueryCar(ctx, carId)
 	if err != nil {
 		return err
 	}
+	if err := requireSubmitterIs(ctx, car, roleManufacturer, car.ManufacturerId); err != nil {
+		return err
+	}
+	if err := requireActiveParticipant(ctx, roleManufacturer, car.ManufacturerId); err != nil {
+		return err
+	}
+	if err := requireActiveParticipant(ctx, roleDealer, dealerId); err != nil {
+		return err
+	}
+	if err := transition(car, statusShipped); err != nil {
+		return err
+	}
 	car.DealerId = dealerId
-	car.Status = "SHIPPED"
-	car.ShippingDate = time.Now().Format("2022-04-10 15:04:05")
-	car.ShippingPrice = shippingPrice
-	carAsBytes, _ := json.Marshal(car)
+	car.ShipmentId = ctx.GetStub().GetTxID()
+	car.CarrierId = carrierId
+	car.ShippingDate, err = txDate(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := s.recordOdometer(ctx, car, odometer, car.Status); err != nil {
+		return err
+	}
+
+	prices, err := transientPrices(ctx)
+	if err != nil {
+		return err
+	}
+	price := new(ManufacturerDealerPrice)
+	if err := getPrivate(ctx, manufacturerDealerCollection, carId, price); err != nil {
+		return err
+	}
+	price.DealerId = dealerId
+	price.ShippingPrice = prices.ShippingPrice
+	price.Salt = prices.Salt
+	if err := putPrivate(ctx, manufacturerDealerCollection, carId, price); err != nil {
+		return err
+	}
+	if err := payForShipment(ctx, carId, dealerId, car.ManufacturerId, price.ManufacturerPrice+price.ShippingPrice, price.Salt); err != nil {
+		return err
+	}
 
-	return ctx.GetStub().PutState(carId, carAsBytes)
+	return s.putCar(ctx, carId, car)
 }
 
-// Delear received the shipment and updates the delivery details for given carId in world state
-func (s *CarChainCode) ReceiveDelivery(ctx contractapi.TransactionContextInterface, carId string, role string) error {
-	if role != "dealer" {
-		return fmt.Errorf("Failed to put to world state due to unauthorized user")
+// Delear received the shipment and updates the delivery details for given carId in world state.
+func (s *CarChainCode) ReceiveDelivery(ctx contractapi.TransactionContextInterface, carId string, odometer int) error {
+	if err := s.requireRole(ctx, roleDealer); err != nil {
+		return err
 	}
 	car, err := s.QueryCar(ctx, carId)
 	if err != nil {
 		return err
 	}
-	car.Status = "READY_FOR_SALE"
-	car.DeliveryDate = time.Now().Format("2022-04-10 15:04:05")
-	carAsBytes, _ := json.Marshal(car)
+	if err := requireSubmitterIs(ctx, car, roleDealer, car.DealerId); err != nil {
+		return err
+	}
+	if err := requireActiveParticipant(ctx, roleDealer, car.DealerId); err != nil {
+		return err
+	}
+	if err := transition(car, statusReadyForSale); err != nil {
+		return err
+	}
+	car.DeliveryDate, err = txDate(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := s.recordOdometer(ctx, car, odometer, car.Status); err != nil {
+		return err
+	}
 
-	return ctx.GetStub().PutState(carId, carAsBytes)
+	return s.putCar(ctx, carId, car)
 }
 
 // Delear sell the car to customer and updates the sell details for given carId in world state
-func (s *CarChainCode) SellToCustomer(ctx contractapi.TransactionContextInterface, carId string, consumerId string, customerPrice int, role string) error {
-	if role != "dealer" {
-		return fmt.Errorf("Failed to put to world state due to unauthorized user")
+// and records the customer price, passed in the transient map, in the dealer-consumer collection under the sale's txId.
+// The consumer pays the customer price in settlement tokens out of its allowance for the dealer.
+// A first sale starts the car's warranty from its model's warranty policy. A car under an active lien cannot be sold.
+func (s *CarChainCode) SellToCustomer(ctx contractapi.TransactionContextInterface, carId string, consumerId string, odometer int) error {
+	if err := s.requireRole(ctx, roleDealer); err != nil {
+		return err
 	}
 
 	car, err := s.QueryCar(ctx, carId)
 	if err != nil {
 		return err
 	}
-	car.Status = "SOLD"
+	if err := transition(car, statusSold); err != nil {
+		return err
+	}
+	if err := requireNoOpenRecalls(car); err != nil {
+		return err
+	}
+	if err := requireNoActiveLien(car); err != nil {
+		return err
+	}
+	if err := requireSubmitterIs(ctx, car, roleDealer, car.DealerId); err != nil {
+		return err
+	}
+	if err := requireActiveParticipant(ctx, roleDealer, car.DealerId); err != nil {
+		return err
+	}
+	if err := requireActiveParticipant(ctx, roleConsumer, consumerId); err != nil {
+		return err
+	}
+	resale := len(ownersOf(car)) > 0
 	car.ConsumerId = consumerId
-	car.SoldOnDate = time.Now().Format("2022-04-10 15:04:05")
-	car.CustomerPrice = customerPrice
+	car.SoldOnDate, err = txDate(ctx)
+	if err != nil {
+		return err
+	}
+	car.Owners = append(ownersOf(car), Ownership{OwnerId: consumerId, OwnerType: roleConsumer, Since: car.SoldOnDate, TxId: ctx.GetStub().GetTxID()})
+	if _, err := s.recordOdometer(ctx, car, odometer, car.Status); err != nil {
+		return err
+	}
 
-	carAsBytes, _ := json.Marshal(car)
+	prices, err := transientPrices(ctx)
+	if err != nil {
+		return err
+	}
+	price := DealerConsumerPrice{
+		CarId:         carId,
+		DealerId:      car.DealerId,
+		ConsumerId:    consumerId,
+		SoldOnDate:    car.SoldOnDate,
+		TxId:          ctx.GetStub().GetTxID(),
+		Resale:        resale,
+		CustomerPrice: prices.CustomerPrice,
+		Salt:          prices.Salt,
+	}
+	if err := putPrivateAsset(ctx, dealerConsumerCollection, dealerConsumerSaleType, []string{carId, price.TxId}, price); err != nil {
+		return err
+	}
+	if err := settle(ctx, roleConsumer, consumerId, roleDealer, car.DealerId, price.CustomerPrice, price.Salt); err != nil {
+		return err
+	}
+	if err := startWarranty(ctx, car); err != nil {
+		return err
+	}
 
-	return ctx.GetStub().PutState(carId, carAsBytes)
+	return s.putCar(ctx, carId, car)
 }
 
 func main() {
 
-	chaincode, err := contractapi.NewChaincode(new(CarChainCode))
+	roleRules, err := loadRoleRules()
+	if err != nil {
+		fmt.Printf("Error while loading role rules: %s", err.Error())
+		return
+	}
+
+	maxBatchSize, err := loadMaxBatchSize()
+	if err != nil {
+		fmt.Printf("Error while loading maximum batch size: %s", err.Error())
+		return
+	}
+
+	chaincode, err := contractapi.NewChaincode(&CarChainCode{roleRules: roleRules, maxBatchSize: maxBatchSize})
 
 	if err != nil {
 		fmt.Printf("Error while creating Car Chain Code: %s", err.Error())
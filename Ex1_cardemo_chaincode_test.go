@@ -0,0 +1,1946 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// attrOID is the X.509 extension Fabric CA uses to carry identity attributes
+var attrOID = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}
+
+// fabricateCert builds a self-signed PEM certificate carrying the given Fabric CA attributes
+func fabricateCert(t *testing.T, attrs map[string]string) []byte {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "cardemo-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	if attrs != nil {
+		value, err := json.Marshal(map[string]interface{}{"attrs": attrs})
+		if err != nil {
+			t.Fatalf("Failed to marshal attributes: %s", err)
+		}
+		template.ExtraExtensions = []pkix.Extension{{Id: attrOID, Value: value}}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %s", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// newTestStub returns a mock stub with an open transaction and prices in its transient map
+func newTestStub() *shimtest.MockStub {
+	stub := shimtest.NewMockStub("cardemo", nil)
+	stub.MockTransactionStart("tx1")
+	withPrices(stub, CarPrices{ManufacturerPrice: 350000, ShippingPrice: 10000, CustomerPrice: 550000, Salt: "test-salt"})
+
+	participants := map[string][]string{
+		roleManufacturer: {"MOrg01", "MOrg02"},
+		roleDealer:       {"D101", "D102"},
+		roleConsumer:     {"CUST101", "CUST102"},
+		roleLender:       {"BANK1", "BANK2"},
+	}
+	for participantType, ids := range participants {
+		for _, id := range ids {
+			putTestAsset(stub, participantDocType, []string{participantType, id}, Participant{DocType: participantDocType, ParticipantType: participantType, ParticipantId: id, Name: id, MSPID: defaultRoleMSPs[participantType][0], Active: true})
+		}
+	}
+
+	// dealers pay manufacturers and consumers pay dealers, so fund them and approve their counterparties
+	payees := map[string]string{roleDealer: roleManufacturer, roleConsumer: roleDealer}
+	for payerType, payeeType := range payees {
+		for _, payerId := range participants[payerType] {
+			putTestPrivateAsset(stub, settlementCollection, tokenAccountType, []string{payerType, payerId}, TokenAccount{DocType: tokenAccountType, OwnerType: payerType, OwnerId: payerId, Balance: 10000000, Salt: "test-salt"})
+			for _, payeeId := range participants[payeeType] {
+				putTestPrivateAsset(stub, settlementCollection, tokenAllowanceType, []string{payerType, payerId, payeeType, payeeId}, TokenAllowance{DocType: tokenAllowanceType, OwnerType: payerType, OwnerId: payerId, SpenderType: payeeType, SpenderId: payeeId, Amount: 10000000, Salt: "test-salt"})
+			}
+		}
+	}
+
+	return stub
+}
+
+// putTestAsset writes the asset as JSON under the composite key, bypassing the transactions that guard it
+func putTestAsset(stub *shimtest.MockStub, objectType string, attributes []string, asset interface{}) {
+	key, _ := stub.CreateCompositeKey(objectType, attributes)
+	assetAsBytes, _ := json.Marshal(asset)
+	_ = stub.PutState(key, assetAsBytes)
+}
+
+// putTestPrivateAsset writes the asset as JSON to the collection under the composite key, bypassing the transactions that guard it
+func putTestPrivateAsset(stub *shimtest.MockStub, collection string, objectType string, attributes []string, asset interface{}) {
+	key, _ := stub.CreateCompositeKey(objectType, attributes)
+	assetAsBytes, _ := json.Marshal(asset)
+	_ = stub.PutPrivateData(collection, key, assetAsBytes)
+}
+
+// cannedStub answers the queries shimtest.MockStub does not implement with canned results
+type cannedStub struct {
+	*shimtest.MockStub
+	history    []*queryresult.KeyModification
+	historyKey string
+	page       []*queryresult.KV
+	metadata   *pb.QueryResponseMetadata
+	pageSize   int32
+	bookmark   string
+}
+
+// GetHistoryForKey returns the canned history whatever the key, remembering the key asked for
+func (stub *cannedStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	stub.historyKey = key
+	return &cannedHistoryIterator{modifications: stub.history}, nil
+}
+
+// GetStateByPartialCompositeKeyWithPagination returns the canned page whatever the query, remembering the page size and bookmark asked for
+func (stub *cannedStub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	stub.pageSize = pageSize
+	stub.bookmark = bookmark
+	return &cannedStateIterator{results: stub.page}, stub.metadata, nil
+}
+
+// cannedStateIterator iterates over a fixed list of state entries
+type cannedStateIterator struct {
+	results []*queryresult.KV
+}
+
+func (it *cannedStateIterator) HasNext() bool {
+	return len(it.results) > 0
+}
+
+func (it *cannedStateIterator) Next() (*queryresult.KV, error) {
+	if len(it.results) == 0 {
+		return nil, errors.New("No more results")
+	}
+	next := it.results[0]
+	it.results = it.results[1:]
+	return next, nil
+}
+
+func (it *cannedStateIterator) Close() error {
+	return nil
+}
+
+// cannedHistoryIterator iterates over a fixed list of key modifications
+type cannedHistoryIterator struct {
+	modifications []*queryresult.KeyModification
+}
+
+func (it *cannedHistoryIterator) HasNext() bool {
+	return len(it.modifications) > 0
+}
+
+func (it *cannedHistoryIterator) Next() (*queryresult.KeyModification, error) {
+	if len(it.modifications) == 0 {
+		return nil, errors.New("No more modifications")
+	}
+	next := it.modifications[0]
+	it.modifications = it.modifications[1:]
+	return next, nil
+}
+
+func (it *cannedHistoryIterator) Close() error {
+	return nil
+}
+
+// withPrices passes the prices, and their salt for the token records, to the next transaction through the transient map
+func withPrices(stub *shimtest.MockStub, prices CarPrices) {
+	pricesAsBytes, _ := json.Marshal(prices)
+	stub.TransientMap = map[string][]byte{transientPricesKey: pricesAsBytes, transientSaltKey: []byte(prices.Salt)}
+}
+
+// asSubmitter makes the stub's creator a fabricated identity and returns a matching transaction context
+func asSubmitter(t *testing.T, stub *shimtest.MockStub, mspID string, attrs map[string]string) *contractapi.TransactionContext {
+	t.Helper()
+
+	creator, err := proto.Marshal(&msp.SerializedIdentity{Mspid: mspID, IdBytes: fabricateCert(t, attrs)})
+	if err != nil {
+		t.Fatalf("Failed to marshal creator: %s", err)
+	}
+	stub.Creator = creator
+
+	identity, err := cid.New(stub)
+	if err != nil {
+		t.Fatalf("Failed to read client identity: %s", err)
+	}
+
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(stub)
+	ctx.SetClientIdentity(identity)
+
+	return ctx
+}
+
+func TestCreateCarRequiresManufacturerCertificate(t *testing.T) {
+	s := new(CarChainCode)
+	stub := newTestStub()
+
+	ctx := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "dealer"})
+	if _, err := s.CreateCar(ctx, CarInput{ManufacturerId: "MOrg01", CarId: "M201", CarMake: "2022", CarModel: "MOrg01CM201", CarColor: "Red", ManufacturingDate: "2022-01-01T00:00:00Z"}); err == nil {
+		t.Fatal("Expected dealer certificate to be refused")
+	}
+
+	ctx = asSubmitter(t, stub, "Org1MSP", nil)
+	if _, err := s.CreateCar(ctx, CarInput{ManufacturerId: "MOrg01", CarId: "M201", CarMake: "2022", CarModel: "MOrg01CM201", CarColor: "Red", ManufacturingDate: "2022-01-01T00:00:00Z"}); err == nil {
+		t.Fatal("Expected certificate without role attribute to be refused")
+	}
+
+	ctx = asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "manufacturer", "participantId": "MOrg01"})
+	if _, err := s.CreateCar(ctx, CarInput{ManufacturerId: "MOrg01", CarId: "M201", CarMake: "2022", CarModel: "MOrg01CM201", CarColor: "Red", ManufacturingDate: "2022-01-01T00:00:00Z"}); err != nil {
+		t.Fatalf("Expected manufacturer certificate to be accepted: %s", err)
+	}
+	if _, err := s.CreateCar(ctx, CarInput{ManufacturerId: "MOrg01", CarId: "M201", CarMake: "2022", CarModel: "MOrg01CM201", CarColor: "Blue", ManufacturingDate: "2022-01-01T00:00:00Z"}); err == nil {
+		t.Fatal("Expected an existing car to be refused")
+	}
+	if _, err := s.CreateCar(ctx, CarInput{ManufacturerId: "MOrg02", CarId: "M202", CarMake: "2022", CarModel: "MOrg02CM202", CarColor: "Red", ManufacturingDate: "2022-01-01T00:00:00Z"}); err == nil {
+		t.Fatal("Expected a car under another manufacturer's id to be refused")
+	}
+}
+
+func TestReceiveDeliveryRequiresDealerCertificate(t *testing.T) {
+	s := new(CarChainCode)
+	stub := newTestStub()
+
+	ctx := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "manufacturer", "participantId": "MOrg01"})
+	if _, err := s.CreateCar(ctx, CarInput{ManufacturerId: "MOrg01", CarId: "M201", CarMake: "2022", CarModel: "MOrg01CM201", CarColor: "Red", ManufacturingDate: "2022-01-01T00:00:00Z"}); err != nil {
+		t.Fatalf("Failed to create car: %s", err)
+	}
+	if err := s.ShipToDealer(ctx, "M201", "D101", "CARRIER1", 0); err != nil {
+		t.Fatalf("Failed to ship car: %s", err)
+	}
+	if err := s.ReceiveDelivery(ctx, "M201", 0); err == nil {
+		t.Fatal("Expected manufacturer certificate to be refused by ReceiveDelivery")
+	}
+
+	ctx = asSubmitter(t, stub, "Org2MSP", map[string]string{"role": "dealer", "participantId": "D101"})
+	if err := s.ReceiveDelivery(ctx, "M201", 0); err != nil {
+		t.Fatalf("Expected dealer certificate to be accepted: %s", err)
+	}
+}
+
+func TestOnlyTheCarsManufacturerShipsIt(t *testing.T) {
+	s := new(CarChainCode)
+	stub := newTestStub()
+	manufacturer := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "manufacturer", "participantId": "MOrg01"})
+	otherManufacturer := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "manufacturer", "participantId": "MOrg02"})
+
+	if _, err := s.CreateCar(manufacturer, CarInput{ManufacturerId: "MOrg01", CarId: "M201", CarMake: "2022", CarModel: "MOrg01CM201", CarColor: "Red", ManufacturingDate: "2022-01-01T00:00:00Z"}); err != nil {
+		t.Fatalf("Failed to create car: %s", err)
+	}
+	if err := s.ShipToDealer(otherManufacturer, "M201", "D101", "CARRIER1", 0); err == nil {
+		t.Fatal("Expected another manufacturer to be refused")
+	}
+	if err := s.ShipToDealer(asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "manufacturer"}), "M201", "D101", "CARRIER1", 0); err == nil {
+		t.Fatal("Expected a manufacturer without a participant id to be refused")
+	}
+	if payment, _ := getShipmentPayment(manufacturer, "M201"); payment != nil {
+		t.Fatal("Expected the refused shipments to charge the dealer nothing")
+	}
+
+	if err := s.ShipToDealer(manufacturer, "M201", "D101", "CARRIER1", 0); err != nil {
+		t.Fatalf("Expected the car's manufacturer to ship it: %s", err)
+	}
+}
+
+func TestRoleRulesMapMSPAndAttribute(t *testing.T) {
+	s := &CarChainCode{roleRules: []RoleRule{
+		{MSPID: "Org1MSP", Role: roleManufacturer},
+		{MSPID: "Org2MSP", Attribute: "dept", Value: "sales", Role: roleDealer},
+	}}
+	stub := newTestStub()
+
+	role, err := s.submitterRole(asSubmitter(t, stub, "Org1MSP", nil))
+	if err != nil || role != roleManufacturer {
+		t.Fatalf("Expected Org1MSP to map to manufacturer, got %q (%v)", role, err)
+	}
+
+	role, err = s.submitterRole(asSubmitter(t, stub, "Org2MSP", map[string]string{"dept": "sales"}))
+	if err != nil || role != roleDealer {
+		t.Fatalf("Expected Org2MSP sales to map to dealer, got %q (%v)", role, err)
+	}
+
+	if _, err := s.submitterRole(asSubmitter(t, stub, "Org2MSP", map[string]string{"role": "manufacturer"})); err == nil {
+		t.Fatal("Expected role attribute to be ignored when no rule references it")
+	}
+
+	if _, err := s.submitterRole(asSubmitter(t, stub, "Org3MSP", nil)); err == nil {
+		t.Fatal("Expected unmapped MSP to have no role")
+	}
+}
+
+func TestDefaultRoleRulesArePinnedToMSPs(t *testing.T) {
+	s := new(CarChainCode)
+	stub := newTestStub()
+
+	role, err := s.submitterRole(asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "admin"}))
+	if err != nil || role != roleAdmin {
+		t.Fatalf("Expected Org1MSP role=admin to map to admin, got %q (%v)", role, err)
+	}
+
+	for mspID, role := range map[string]string{"Org3MSP": roleAdmin, "Org9MSP": roleManufacturer, "Org2MSP": roleRegulator} {
+		if _, err := s.submitterRole(asSubmitter(t, stub, mspID, map[string]string{"role": role})); err == nil {
+			t.Fatalf("Expected %s to be refused role=%s", mspID, role)
+		}
+	}
+}
+
+func TestLoadRoleRules(t *testing.T) {
+	t.Setenv(roleRulesEnv, `[{"mspId":"Org1MSP","role":"manufacturer"}]`)
+	rules, err := loadRoleRules()
+	if err != nil || len(rules) != 1 || rules[0].Role != roleManufacturer {
+		t.Fatalf("Unexpected rules %v (%v)", rules, err)
+	}
+
+	t.Setenv(roleRulesEnv, `[{"mspId":"Org1MSP"}]`)
+	if _, err := loadRoleRules(); err == nil {
+		t.Fatal("Expected rule without role to be rejected")
+	}
+
+	t.Setenv(roleRulesEnv, "")
+	rules, err = loadRoleRules()
+	if err != nil || len(rules) != len(defaultRoleRules()) {
+		t.Fatalf("Expected default rules, got %v (%v)", rules, err)
+	}
+}
+
+func TestGetCarHistoryDecodesEveryVersion(t *testing.T) {
+	s := new(CarChainCode)
+	stub := &cannedStub{MockStub: newTestStub()}
+	ctx := asSubmitter(t, stub.MockStub, "Org1MSP", map[string]string{"role": "manufacturer", "participantId": "MOrg01"})
+	ctx.SetStub(stub)
+
+	created, _ := json.Marshal(Car{CarId: "M201", ManufacturerId: "MOrg01", Status: statusCreated})
+	shipped, _ := json.Marshal(Car{CarId: "M201", ManufacturerId: "MOrg01", DealerId: "D101", Status: statusShipped})
+	stub.history = []*queryresult.KeyModification{
+		{TxId: "tx1", Value: created, Timestamp: &timestamp.Timestamp{Seconds: 1650000000}},
+		{TxId: "tx2", Value: shipped, Timestamp: &timestamp.Timestamp{Seconds: 1650000060, Nanos: 500}},
+		{TxId: "tx3", IsDelete: true, Timestamp: &timestamp.Timestamp{Seconds: 1650000120}},
+	}
+
+	history, err := s.GetCarHistory(ctx, "M201")
+	if err != nil || len(history) != 3 {
+		t.Fatalf("Expected 3 history entries, got %+v (%v)", history, err)
+	}
+	if key, _ := stub.CreateCompositeKey(carKeyType, []string{"M201"}); stub.historyKey != key {
+		t.Fatalf("Expected the history of the car key, got %q", stub.historyKey)
+	}
+	if history[0].TxId != "tx1" || history[0].Timestamp != "2022-04-15T05:20:00Z" || history[0].Record == nil || history[0].Record.Status != statusCreated {
+		t.Fatalf("Unexpected first entry %+v", history[0])
+	}
+	if history[1].Timestamp != "2022-04-15T05:21:00.0000005Z" || history[1].Record == nil || history[1].Record.DealerId != "D101" {
+		t.Fatalf("Unexpected second entry %+v", history[1])
+	}
+	if !history[2].IsDelete || history[2].Record != nil || history[2].TxId != "tx3" {
+		t.Fatalf("Expected the deletion without a record, got %+v", history[2])
+	}
+
+	stub.history = nil
+	if _, err := s.GetCarHistory(ctx, "M202"); err == nil {
+		t.Fatal("Expected a car without history to be reported missing")
+	}
+
+	stub.history = []*queryresult.KeyModification{{TxId: "tx1", Value: []byte("{not json")}}
+	if _, err := s.GetCarHistory(ctx, "M201"); err == nil {
+		t.Fatal("Expected an undecodable version to fail")
+	}
+}
+
+func TestQueryAllCarsWithPaginationReturnsOnePage(t *testing.T) {
+	s := new(CarChainCode)
+	stub := &cannedStub{MockStub: newTestStub()}
+	ctx := asSubmitter(t, stub.MockStub, "Org1MSP", map[string]string{"role": "manufacturer", "participantId": "MOrg01"})
+	ctx.SetStub(stub)
+
+	for _, car := range []Car{
+		{CarId: "M201", ManufacturerId: "MOrg01", Status: statusCreated},
+		{CarId: "M202", ManufacturerId: "MOrg01", Status: statusScrapped},
+		{CarId: "M203", ManufacturerId: "MOrg01", Status: statusShipped},
+	} {
+		key, _ := stub.CreateCompositeKey(carKeyType, []string{car.CarId})
+		carAsBytes, _ := json.Marshal(car)
+		stub.page = append(stub.page, &queryresult.KV{Key: key, Value: carAsBytes})
+	}
+	stub.metadata = &pb.QueryResponseMetadata{FetchedRecordsCount: 3, Bookmark: "M204"}
+
+	result, err := s.QueryAllCarsWithPagination(ctx, 3, "M201", false)
+	if err != nil {
+		t.Fatalf("Failed to query a page of cars: %s", err)
+	}
+	if stub.pageSize != 3 || stub.bookmark != "M201" {
+		t.Fatalf("Expected page size 3 from bookmark M201, queried %d from %q", stub.pageSize, stub.bookmark)
+	}
+	if result.Bookmark != "M204" || result.FetchedRecordsCount != 3 {
+		t.Fatalf("Expected the next bookmark and fetched count from the ledger, got %q and %d", result.Bookmark, result.FetchedRecordsCount)
+	}
+	if len(result.Records) != 2 || result.Records[0].Key != "M201" || result.Records[1].Key != "M203" {
+		t.Fatalf("Expected M201 and M203 keyed by car id without the scrapped car, got %+v", result.Records)
+	}
+
+	result, err = s.QueryAllCarsWithPagination(ctx, 3, "M201", true)
+	if err != nil || len(result.Records) != 3 || result.Records[1].Key != "M202" {
+		t.Fatalf("Expected the scrapped car when asked for, got %+v (%v)", result, err)
+	}
+
+	if _, err := s.QueryAllCarsWithPagination(ctx, 0, "", false); err == nil {
+		t.Fatal("Expected a page size of zero to be refused")
+	}
+}
+
+func TestIllegalTransitionsAreRefused(t *testing.T) {
+	s := new(CarChainCode)
+	stub := newTestStub()
+	manufacturer := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "manufacturer", "participantId": "MOrg01"})
+	dealer := asSubmitter(t, stub, "Org2MSP", map[string]string{"role": "dealer", "participantId": "D101"})
+
+	if _, err := s.CreateCar(manufacturer, CarInput{ManufacturerId: "MOrg01", CarId: "M201", CarMake: "2022", CarModel: "MOrg01CM201", CarColor: "Red", ManufacturingDate: "2022-01-01T00:00:00Z"}); err != nil {
+		t.Fatalf("Failed to create car: %s", err)
+	}
+
+	err := s.SellToCustomer(dealer, "M201", "CUST101", 0)
+	transitionErr := new(TransitionError)
+	if !errors.As(err, &transitionErr) {
+		t.Fatalf("Expected TransitionError selling an unshipped car, got %v", err)
+	}
+	if transitionErr.From != statusCreated || transitionErr.To != statusSold {
+		t.Fatalf("Unexpected transition %s -> %s", transitionErr.From, transitionErr.To)
+	}
+
+	if err := s.ShipToDealer(manufacturer, "M201", "D101", "CARRIER1", 0); err != nil {
+		t.Fatalf("Failed to ship car: %s", err)
+	}
+	if err := s.ShipToDealer(manufacturer, "M201", "D102", "CARRIER1", 0); !errors.As(err, &transitionErr) {
+		t.Fatalf("Expected TransitionError shipping twice, got %v", err)
+	}
+	if err := s.ReceiveDelivery(dealer, "M201", 0); err != nil {
+		t.Fatalf("Failed to receive car: %s", err)
+	}
+	if err := s.SellToCustomer(dealer, "M201", "CUST101", 0); err != nil {
+		t.Fatalf("Failed to sell car: %s", err)
+	}
+	if err := s.ReceiveDelivery(dealer, "M201", 0); !errors.As(err, &transitionErr) {
+		t.Fatalf("Expected TransitionError receiving a sold car, got %v", err)
+	}
+
+	car, err := s.QueryCar(dealer, "M201")
+	if err != nil || car.Status != statusSold || car.DealerId != "D101" {
+		t.Fatalf("Unexpected car after refused transitions %+v (%v)", car, err)
+	}
+}
+
+func TestQueryCarsByIndexFollowsStatusChanges(t *testing.T) {
+	s := new(CarChainCode)
+	stub := newTestStub()
+	manufacturer := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "manufacturer", "participantId": "MOrg01"})
+
+	if _, err := s.CreateCar(manufacturer, CarInput{ManufacturerId: "MOrg01", CarId: "M201", CarMake: "2022", CarModel: "MOrg01CM201", CarColor: "Red", ManufacturingDate: "2022-01-01T00:00:00Z"}); err != nil {
+		t.Fatalf("Failed to create car: %s", err)
+	}
+	if err := s.ShipToDealer(manufacturer, "M201", "D101", "CARRIER1", 0); err != nil {
+		t.Fatalf("Failed to ship car: %s", err)
+	}
+
+	created, err := s.QueryCarsByIndex(manufacturer, CarFilter{Status: statusCreated})
+	if err != nil || len(created) != 0 {
+		t.Fatalf("Expected no CREATED cars, got %v (%v)", created, err)
+	}
+
+	shipped, err := s.QueryCarsByIndex(manufacturer, CarFilter{Status: statusShipped, DealerId: "D101"})
+	if err != nil || len(shipped) != 1 || shipped[0].Key != "M201" {
+		t.Fatalf("Expected M201 SHIPPED to D101, got %v (%v)", shipped, err)
+	}
+
+	other, err := s.QueryCarsByIndex(manufacturer, CarFilter{Status: statusShipped, DealerId: "D102"})
+	if err != nil || len(other) != 0 {
+		t.Fatalf("Expected no cars SHIPPED to D102, got %v (%v)", other, err)
+	}
+}
+
+func TestLifecycleTransitionsEmitEvents(t *testing.T) {
+	s := new(CarChainCode)
+	stub := newTestStub()
+	manufacturer := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "manufacturer", "participantId": "MOrg01"})
+
+	if _, err := s.CreateCar(manufacturer, CarInput{ManufacturerId: "MOrg01", CarId: "M201", CarMake: "2022", CarModel: "MOrg01CM201", CarColor: "Red", ManufacturingDate: "2022-01-01T00:00:00Z"}); err != nil {
+		t.Fatalf("Failed to create car: %s", err)
+	}
+
+	select {
+	case ccEvent := <-stub.ChaincodeEventsChannel:
+		event := new(CarEvent)
+		if err := json.Unmarshal(ccEvent.Payload, event); err != nil {
+			t.Fatalf("Failed to decode event payload: %s", err)
+		}
+		if ccEvent.EventName != eventCarCreated || event.CarId != "M201" || event.Status != statusCreated {
+			t.Fatalf("Unexpected event %s %+v", ccEvent.EventName, event)
+		}
+	default:
+		t.Fatal("Expected a CarCreated event")
+	}
+}
+
+func TestDatesComeFromTransactionTimestamp(t *testing.T) {
+	s := new(CarChainCode)
+	stub := newTestStub()
+	stub.TxTimestamp = &timestamp.Timestamp{Seconds: 1650000000}
+	manufacturer := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "manufacturer", "participantId": "MOrg01"})
+
+	if _, err := s.CreateCar(manufacturer, CarInput{ManufacturerId: "MOrg01", CarId: "M201", CarMake: "2022", CarModel: "MOrg01CM201", CarColor: "Red", ManufacturingDate: "2022/01/01"}); err == nil {
+		t.Fatal("Expected non RFC3339 manufacturing date to be refused")
+	}
+	if _, err := s.CreateCar(manufacturer, CarInput{ManufacturerId: "MOrg01", CarId: "M201", CarMake: "2022", CarModel: "MOrg01CM201", CarColor: "Red", ManufacturingDate: "2022-01-01T00:00:00Z"}); err != nil {
+		t.Fatalf("Failed to create car: %s", err)
+	}
+	if err := s.ShipToDealer(manufacturer, "M201", "D101", "CARRIER1", 0); err != nil {
+		t.Fatalf("Failed to ship car: %s", err)
+	}
+
+	car, err := s.QueryCar(manufacturer, "M201")
+	if err != nil || car.ShippingDate != "2022-04-15T05:20:00Z" {
+		t.Fatalf("Expected shipping date from tx timestamp, got %+v (%v)", car, err)
+	}
+}
+
+func TestMigrateDatesRewritesLegacyDates(t *testing.T) {
+	s := new(CarChainCode)
+	stub := newTestStub()
+	legacy, _ := json.Marshal(Car{CarId: "M101", Status: statusSold, ManufacturingDate: "2022/01/01", SoldOnDate: "2022/04/20"})
+	key, _ := stub.CreateCompositeKey(carKeyType, []string{"M101"})
+	if err := stub.PutState(key, legacy); err != nil {
+		t.Fatalf("Failed to seed legacy car: %s", err)
+	}
+
+	if _, err := s.MigrateDates(asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "dealer"})); err == nil {
+		t.Fatal("Expected MigrateDates to require the admin role")
+	}
+
+	admin := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "admin"})
+	migrated, err := s.MigrateDates(admin)
+	if err != nil || migrated != 1 {
+		t.Fatalf("Expected one migrated car, got %d (%v)", migrated, err)
+	}
+
+	car, err := s.QueryCar(admin, "M101")
+	if err != nil || car.ManufacturingDate != "2022-01-01T00:00:00Z" || car.SoldOnDate != "2022-04-20T00:00:00Z" || car.ShippingDate != "" {
+		t.Fatalf("Unexpected migrated car %+v (%v)", car, err)
+	}
+}
+
+func TestPricesStayInPrivateCollections(t *testing.T) {
+	t.Setenv("CORE_PEER_LOCALMSPID", "Org1MSP")
+	s := new(CarChainCode)
+	stub := newTestStub()
+	manufacturer := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "manufacturer", "participantId": "MOrg01"})
+
+	stub.TransientMap = nil
+	if _, err := s.CreateCar(manufacturer, CarInput{ManufacturerId: "MOrg01", CarId: "M201", CarMake: "2022", CarModel: "MOrg01CM201", CarColor: "Red", ManufacturingDate: "2022-01-01T00:00:00Z"}); err == nil {
+		t.Fatal("Expected CreateCar without transient prices to fail")
+	}
+
+	withPrices(stub, CarPrices{ManufacturerPrice: 350000, Salt: "s1"})
+	if _, err := s.CreateCar(manufacturer, CarInput{ManufacturerId: "MOrg01", CarId: "M201", CarMake: "2022", CarModel: "MOrg01CM201", CarColor: "Red", ManufacturingDate: "2022-01-01T00:00:00Z"}); err != nil {
+		t.Fatalf("Failed to create car: %s", err)
+	}
+	withPrices(stub, CarPrices{ShippingPrice: 12000, Salt: "s2"})
+	if err := s.ShipToDealer(manufacturer, "M201", "D101", "CARRIER1", 0); err != nil {
+		t.Fatalf("Failed to ship car: %s", err)
+	}
+
+	key, _ := stub.CreateCompositeKey(carKeyType, []string{"M201"})
+	public, _ := stub.GetState(key)
+	if bytes.Contains(public, []byte("350000")) || bytes.Contains(public, []byte("Price")) {
+		t.Fatalf("Expected no prices in public state, got %s", public)
+	}
+
+	price, err := s.QueryManufacturerDealerPrice(manufacturer, "M201")
+	if err != nil || price.ManufacturerPrice != 350000 || price.ShippingPrice != 12000 {
+		t.Fatalf("Unexpected private price %+v (%v)", price, err)
+	}
+
+	outsider := asSubmitter(t, stub, "Org2MSP", map[string]string{"role": "dealer"})
+	if _, err := s.QueryManufacturerDealerPrice(outsider, "M201"); err == nil {
+		t.Fatal("Expected a submitter from another organization to be refused")
+	}
+}
+
+func TestTransferOwnershipNeedsBothParties(t *testing.T) {
+	s := new(CarChainCode)
+	stub := newTestStub()
+	manufacturer := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "manufacturer", "participantId": "MOrg01"})
+	dealer := asSubmitter(t, stub, "Org2MSP", map[string]string{"role": "dealer", "participantId": "D101"})
+	owner := asSubmitter(t, stub, "Org3MSP", map[string]string{"role": "consumer", "participantId": "CUST101"})
+	buyer := asSubmitter(t, stub, "Org3MSP", map[string]string{"role": "consumer", "participantId": "CUST102"})
+
+	if _, err := s.CreateCar(manufacturer, CarInput{ManufacturerId: "MOrg01", CarId: "M201", CarMake: "2022", CarModel: "MOrg01CM201", CarColor: "Red", ManufacturingDate: "2022-01-01T00:00:00Z"}); err != nil {
+		t.Fatalf("Failed to create car: %s", err)
+	}
+	if err := s.ShipToDealer(manufacturer, "M201", "D101", "CARRIER1", 0); err != nil {
+		t.Fatalf("Failed to ship car: %s", err)
+	}
+	if err := s.ReceiveDelivery(dealer, "M201", 0); err != nil {
+		t.Fatalf("Failed to receive car: %s", err)
+	}
+	if err := s.SellToCustomer(dealer, "M201", "CUST101", 0); err != nil {
+		t.Fatalf("Failed to sell car: %s", err)
+	}
+
+	if err := s.OfferTransfer(buyer, "M201", "CUST102", roleConsumer); err == nil {
+		t.Fatal("Expected a consumer who does not own the car to be refused")
+	}
+	if err := s.OfferTransfer(owner, "M201", "CUST102", roleConsumer); err != nil {
+		t.Fatalf("Failed to offer transfer: %s", err)
+	}
+	if err := s.AcceptTransfer(dealer, "M201", 0); err == nil {
+		t.Fatal("Expected someone other than the offered owner to be refused")
+	}
+	if err := s.AcceptTransfer(buyer, "M201", 0); err != nil {
+		t.Fatalf("Failed to accept transfer: %s", err)
+	}
+
+	owners, err := s.QueryCarOwners(buyer, "M201")
+	if err != nil || len(owners) != 2 || owners[0].OwnerId != "CUST101" || owners[1].OwnerId != "CUST102" {
+		t.Fatalf("Unexpected owners %+v (%v)", owners, err)
+	}
+
+	if _, err := s.QueryTransferOffer(buyer, "M201"); err == nil {
+		t.Fatal("Expected the accepted offer to be removed")
+	}
+}
+
+func TestOpenRecallBlocksSale(t *testing.T) {
+	s := new(CarChainCode)
+	stub := newTestStub()
+	manufacturer := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "manufacturer", "participantId": "MOrg01"})
+	dealer := asSubmitter(t, stub, "Org2MSP", map[string]string{"role": "dealer", "participantId": "D101"})
+
+	for _, carId := range []string{"M201", "M202"} {
+		if _, err := s.CreateCar(manufacturer, CarInput{ManufacturerId: "MOrg01", CarId: carId, CarMake: "2022", CarModel: "MOrg01CM201", CarColor: "Red", ManufacturingDate: "2022-01-01T00:00:00Z"}); err != nil {
+			t.Fatalf("Failed to create car: %s", err)
+		}
+		if err := s.ShipToDealer(manufacturer, carId, "D101", "CARRIER1", 0); err != nil {
+			t.Fatalf("Failed to ship car: %s", err)
+		}
+		if err := s.ReceiveDelivery(dealer, carId, 0); err != nil {
+			t.Fatalf("Failed to receive car: %s", err)
+		}
+	}
+
+	recall, err := s.CreateRecall(manufacturer, RecallInput{RecallId: "R1", Description: "Brake hose", Criteria: RecallCriteria{CarIds: []string{"M201"}}})
+	if err != nil || len(recall.AffectedCarIds) != 1 {
+		t.Fatalf("Unexpected recall %+v (%v)", recall, err)
+	}
+
+	if err := s.SellToCustomer(dealer, "M201", "CUST101", 0); err == nil {
+		t.Fatal("Expected sale of a recalled car to be blocked")
+	}
+	if err := s.SellToCustomer(dealer, "M202", "CUST102", 0); err != nil {
+		t.Fatalf("Expected sale of an unaffected car to succeed: %s", err)
+	}
+
+	open, err := s.QueryOpenRecallsByDealer(dealer, "D101")
+	if err != nil || len(open) != 1 || open[0].CarId != "M201" {
+		t.Fatalf("Unexpected open recalls %+v (%v)", open, err)
+	}
+
+	if err := s.RecordRecallRemedy(dealer, "R1", "M201", "Hose replaced"); err == nil {
+		t.Fatal("Expected remedy before acknowledgement to be refused")
+	}
+	if err := s.AcknowledgeRecall(dealer, "R1", "M201"); err != nil {
+		t.Fatalf("Failed to acknowledge recall: %s", err)
+	}
+	if err := s.RecordRecallRemedy(dealer, "R1", "M201", "Hose replaced"); err != nil {
+		t.Fatalf("Failed to record remedy: %s", err)
+	}
+	if err := s.SellToCustomer(dealer, "M201", "CUST101", 0); err != nil {
+		t.Fatalf("Expected sale after remedy to succeed: %s", err)
+	}
+
+	recall, err = s.QueryRecall(manufacturer, "R1")
+	if err != nil || recall.Status != recallClosed {
+		t.Fatalf("Expected recall to be closed, got %+v (%v)", recall, err)
+	}
+}
+
+func TestRecallClosesWhenRemainingCarsCannotBeRemedied(t *testing.T) {
+	s := new(CarChainCode)
+	stub := newTestStub()
+	manufacturer := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "manufacturer", "participantId": "MOrg01"})
+	dealer := asSubmitter(t, stub, "Org2MSP", map[string]string{"role": "dealer", "participantId": "D101"})
+	recycler := asSubmitter(t, stub, "Org4MSP", map[string]string{"role": "recycler", "participantId": "RC1"})
+
+	for _, carId := range []string{"M201", "M202", "M203"} {
+		if _, err := s.CreateCar(manufacturer, CarInput{ManufacturerId: "MOrg01", CarId: carId, CarMake: "2022", CarModel: "MOrg01CM201", CarColor: "Red", ManufacturingDate: "2022-01-01T00:00:00Z"}); err != nil {
+			t.Fatalf("Failed to create car: %s", err)
+		}
+		if err := s.ShipToDealer(manufacturer, carId, "D101", "CARRIER1", 0); err != nil {
+			t.Fatalf("Failed to ship car: %s", err)
+		}
+	}
+	for _, carId := range []string{"M201", "M202"} {
+		if err := s.ReceiveDelivery(dealer, carId, 0); err != nil {
+			t.Fatalf("Failed to receive car: %s", err)
+		}
+	}
+	if _, err := s.CreateRecall(manufacturer, RecallInput{RecallId: "R1", Description: "Brake hose", Criteria: RecallCriteria{CarModel: "MOrg01CM201"}}); err != nil {
+		t.Fatalf("Failed to create recall: %s", err)
+	}
+	if _, err := s.ReportTransitException(manufacturer, "M203", statusLost, "Lost at sea"); err != nil {
+		t.Fatalf("Failed to report lost car: %s", err)
+	}
+
+	if err := s.AcknowledgeRecall(dealer, "R1", "M201"); err != nil {
+		t.Fatalf("Failed to acknowledge recall: %s", err)
+	}
+	if err := s.RecordRecallRemedy(dealer, "R1", "M201", "Hose replaced"); err != nil {
+		t.Fatalf("Failed to record remedy: %s", err)
+	}
+	if recall, _ := s.QueryRecall(manufacturer, "R1"); recall.Status != recallOpen {
+		t.Fatalf("Expected the recall to stay open while the dealer holds M202, got %+v", recall)
+	}
+	if err := s.CloseRecall(manufacturer, "R1"); err == nil {
+		t.Fatal("Expected a recall with a car the dealer can remedy to stay open")
+	}
+
+	if err := s.ScrapCar(recycler, "M202", "cert-hash", 0); err != nil {
+		t.Fatalf("Failed to scrap car: %s", err)
+	}
+	if err := s.CloseRecall(asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "manufacturer", "participantId": "MOrg02"}), "R1"); err == nil {
+		t.Fatal("Expected another manufacturer to be refused")
+	}
+	if err := s.CloseRecall(manufacturer, "R1"); err != nil {
+		t.Fatalf("Expected the issuing manufacturer to close the recall: %s", err)
+	}
+	if recall, _ := s.QueryRecall(manufacturer, "R1"); recall.Status != recallClosed || recall.RemediedCount != 1 {
+		t.Fatalf("Expected the recall closed with one remedy, got %+v", recall)
+	}
+	if err := s.CloseRecall(manufacturer, "R1"); err == nil {
+		t.Fatal("Expected a closed recall to be refused")
+	}
+}
+
+func TestServiceRecordsAreCheckedAndOrdered(t *testing.T) {
+	s := new(CarChainCode)
+	stub := newTestStub()
+	manufacturer := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "manufacturer", "participantId": "MOrg01"})
+	dealer := asSubmitter(t, stub, "Org2MSP", map[string]string{"role": "dealer", "participantId": "D101"})
+	serviceCenter := asSubmitter(t, stub, "Org4MSP", map[string]string{"role": "servicecenter", "participantId": "SC1"})
+	valid := ServiceRecordInput{CarId: "M201", ServiceDate: "2023-01-10T00:00:00Z", Odometer: 1000, WorkPerformed: "Oil change", Cost: 150}
+
+	if _, err := s.CreateCar(manufacturer, CarInput{ManufacturerId: "MOrg01", CarId: "M201", CarMake: "2022", CarModel: "MOrg01CM201", CarColor: "Red", ManufacturingDate: "2022-01-01T00:00:00Z"}); err != nil {
+		t.Fatalf("Failed to create car: %s", err)
+	}
+	if err := s.ShipToDealer(manufacturer, "M201", "D101", "CARRIER1", 0); err != nil {
+		t.Fatalf("Failed to ship car: %s", err)
+	}
+	if err := s.ReceiveDelivery(dealer, "M201", 0); err != nil {
+		t.Fatalf("Failed to receive car: %s", err)
+	}
+	if _, err := s.AddServiceRecord(serviceCenter, valid); err == nil {
+		t.Fatal("Expected a car that is not sold yet to be refused")
+	}
+	if err := s.SellToCustomer(dealer, "M201", "CUST101", 0); err != nil {
+		t.Fatalf("Failed to sell car: %s", err)
+	}
+
+	if _, err := s.AddServiceRecord(dealer, valid); err == nil {
+		t.Fatal("Expected AddServiceRecord to require the servicecenter role")
+	}
+	if _, err := s.AddServiceRecord(asSubmitter(t, stub, "Org4MSP", map[string]string{"role": "servicecenter"}), valid); err == nil {
+		t.Fatal("Expected a service centre without a participant id to be refused")
+	}
+
+	invalid := map[string]ServiceRecordInput{
+		"unknown car":       {CarId: "M999", ServiceDate: valid.ServiceDate, Odometer: 1000, WorkPerformed: "Oil change", Cost: 150},
+		"bad service date":  {CarId: "M201", ServiceDate: "2023/01/10", Odometer: 1000, WorkPerformed: "Oil change", Cost: 150},
+		"no work":           {CarId: "M201", ServiceDate: valid.ServiceDate, Odometer: 1000, Cost: 150},
+		"negative odometer": {CarId: "M201", ServiceDate: valid.ServiceDate, Odometer: -1, WorkPerformed: "Oil change", Cost: 150},
+		"negative cost":     {CarId: "M201", ServiceDate: valid.ServiceDate, Odometer: 1000, WorkPerformed: "Oil change", Cost: -150},
+	}
+	for name, input := range invalid {
+		if _, err := s.AddServiceRecord(serviceCenter, input); err == nil {
+			t.Fatalf("Expected a service record with %s to be refused", name)
+		}
+	}
+
+	// txIds sort one way, service dates another, and the two records of 2023-03-05 differ only in when they were recorded
+	visits := []struct {
+		txId        string
+		recordedAt  int64
+		serviceDate string
+		odometer    int
+	}{
+		{"svc1", 1700000200, "2023-06-01T00:00:00Z", 1000},
+		{"svc2", 1700000100, "2023-03-05T00:00:00Z", 2000},
+		{"svc3", 1700000000, "2023-03-05T00:00:00Z", 3000},
+	}
+	for _, visit := range visits {
+		stub.MockTransactionStart(visit.txId)
+		stub.TxTimestamp = &timestamp.Timestamp{Seconds: visit.recordedAt}
+		input := ServiceRecordInput{CarId: "M201", ServiceDate: visit.serviceDate, Odometer: visit.odometer, WorkPerformed: "Inspection", Cost: 90}
+		if record, err := s.AddServiceRecord(serviceCenter, input); err != nil || record.ServiceCenterId != "SC1" || record.TxId != visit.txId || record.PartsReplaced == nil {
+			t.Fatalf("Failed to add service record %s: %+v (%v)", visit.txId, record, err)
+		}
+	}
+
+	records, err := s.QueryServiceRecords(dealer, "M201")
+	if err != nil || len(records) != 3 {
+		t.Fatalf("Expected 3 service records, got %+v (%v)", records, err)
+	}
+	for i, txId := range []string{"svc3", "svc2", "svc1"} {
+		if records[i].TxId != txId {
+			t.Fatalf("Expected records ordered by service date then recording time, got %+v", records)
+		}
+	}
+
+	if car, _ := s.QueryCar(dealer, "M201"); car.Odometer != 3000 {
+		t.Fatalf("Expected the visits to record the odometer, got %+v", car)
+	}
+}
+
+func TestCreateCarValidatesVin(t *testing.T) {
+	s := new(CarChainCode)
+	stub := newTestStub()
+	admin := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "admin"})
+	manufacturer := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "manufacturer", "participantId": "MOrg01"})
+
+	info, err := s.DecodeVin(manufacturer, "1HGCM82633A004352")
+	if err != nil || info.Wmi != "1HG" || info.ModelYear != 2003 || info.SerialNumber != "004352" {
+		t.Fatalf("Unexpected decoded VIN %+v (%v)", info, err)
+	}
+	if _, err := s.DecodeVin(manufacturer, "1HGCM82643A004352"); err == nil {
+		t.Fatal("Expected a wrong check digit to be refused")
+	}
+
+	if _, err := s.CreateCar(manufacturer, CarInput{ManufacturerId: "MOrg01", CarId: "1HGCM82633A004352", CarMake: "2003", CarModel: "Accord", CarColor: "Silver", ManufacturingDate: "2003-01-01T00:00:00Z"}); err == nil {
+		t.Fatal("Expected an unregistered WMI to be refused")
+	}
+	if err := s.RegisterWmi(admin, "1HG", "MOrg01"); err != nil {
+		t.Fatalf("Failed to register WMI: %s", err)
+	}
+	if _, err := s.CreateCar(asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "manufacturer", "participantId": "MOrg02"}), CarInput{ManufacturerId: "MOrg02", CarId: "1HGCM82633A004352", CarMake: "2003", CarModel: "Accord", CarColor: "Silver", ManufacturingDate: "2003-01-01T00:00:00Z"}); err == nil {
+		t.Fatal("Expected a VIN of another manufacturer to be refused")
+	}
+	if _, err := s.CreateCar(manufacturer, CarInput{ManufacturerId: "MOrg01", CarId: "1HGCM82633A004352", CarMake: "2022", CarModel: "Accord", CarColor: "Silver", ManufacturingDate: "2003-01-01T00:00:00Z"}); err == nil {
+		t.Fatal("Expected a carMake that contradicts the model year to be refused")
+	}
+	if _, err := s.CreateCar(manufacturer, CarInput{ManufacturerId: "MOrg01", CarId: "1hgcm82633a004352", CarMake: "2003", CarModel: "Accord", CarColor: "Silver", ManufacturingDate: "2003-01-01T00:00:00Z"}); err != nil {
+		t.Fatalf("Failed to create car by VIN: %s", err)
+	}
+
+	car, err := s.QueryCar(manufacturer, "1HGCM82633A004352")
+	if err != nil || car.Vin != "1HGCM82633A004352" {
+		t.Fatalf("Expected car keyed by VIN, got %+v (%v)", car, err)
+	}
+}
+
+func TestWmiIsOnlyReassignedByTransfer(t *testing.T) {
+	s := new(CarChainCode)
+	stub := newTestStub()
+	admin := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "admin"})
+
+	if err := s.RegisterWmi(admin, "1HG", "MOrg09"); err == nil {
+		t.Fatal("Expected an unregistered manufacturer to be refused")
+	}
+	if err := s.RegisterWmi(admin, "1HG", "MOrg01"); err != nil {
+		t.Fatalf("Failed to register WMI: %s", err)
+	}
+	if err := s.RegisterWmi(admin, "1hg", "MOrg02"); err == nil {
+		t.Fatal("Expected a registered WMI to be refused")
+	}
+	if err := s.TransferWmi(admin, "1HG", "MOrg02", "MOrg02"); err == nil {
+		t.Fatal("Expected a transfer from the wrong manufacturer to be refused")
+	}
+	if err := s.TransferWmi(admin, "1HG", "MOrg01", "MOrg02"); err != nil {
+		t.Fatalf("Failed to transfer WMI: %s", err)
+	}
+
+	registration := new(WmiRegistration)
+	if found, err := getAsset(admin, wmiType, []string{"1HG"}, registration); !found || err != nil || registration.ManufacturerId != "MOrg02" {
+		t.Fatalf("Expected WMI to be transferred to MOrg02, got %+v (%v)", registration, err)
+	}
+}
+
+func TestDeactivatedParticipantsAreRefused(t *testing.T) {
+	s := new(CarChainCode)
+	stub := newTestStub()
+	manufacturer := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "manufacturer", "participantId": "MOrg01"})
+
+	if _, err := s.CreateCar(manufacturer, CarInput{ManufacturerId: "MOrg09", CarId: "M201", CarMake: "2022", CarModel: "MOrg01CM201", CarColor: "Red", ManufacturingDate: "2022-01-01T00:00:00Z"}); err == nil {
+		t.Fatal("Expected an unregistered manufacturer to be refused")
+	}
+	if _, err := s.CreateCar(manufacturer, CarInput{ManufacturerId: "MOrg01", CarId: "M201", CarMake: "2022", CarModel: "MOrg01CM201", CarColor: "Red", ManufacturingDate: "2022-01-01T00:00:00Z"}); err != nil {
+		t.Fatalf("Failed to create car: %s", err)
+	}
+	if err := s.ShipToDealer(manufacturer, "M201", "D109", "CARRIER1", 0); err == nil {
+		t.Fatal("Expected an unregistered dealer to be refused")
+	}
+
+	if err := s.DeactivateParticipant(manufacturer, roleDealer, "D101"); err == nil {
+		t.Fatal("Expected DeactivateParticipant to require the admin role")
+	}
+
+	admin := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "admin"})
+	if err := s.DeactivateParticipant(admin, roleDealer, "D101"); err != nil {
+		t.Fatalf("Failed to deactivate dealer: %s", err)
+	}
+	if err := s.ShipToDealer(manufacturer, "M201", "D101", "CARRIER1", 0); err == nil {
+		t.Fatal("Expected a deactivated dealer to be refused")
+	}
+
+	if _, err := s.RegisterParticipant(admin, ParticipantInput{ParticipantType: roleDealer, ParticipantId: "D101", Name: "Dealer 101", MSPID: "Org2MSP"}); err == nil {
+		t.Fatal("Expected a duplicate registration to be refused")
+	}
+	if _, err := s.RegisterParticipant(admin, ParticipantInput{ParticipantType: roleDealer, ParticipantId: "D103", Name: "Dealer 103"}); err == nil {
+		t.Fatal("Expected a registration without an MSP ID to be refused")
+	}
+	if _, err := s.RegisterParticipant(admin, ParticipantInput{ParticipantType: roleDealer, ParticipantId: "D103", Name: "Dealer 103", MSPID: "Org2MSP"}); err != nil {
+		t.Fatalf("Failed to register dealer: %s", err)
+	}
+	if err := s.ShipToDealer(manufacturer, "M201", "D103", "CARRIER1", 0); err != nil {
+		t.Fatalf("Expected the newly registered dealer to be accepted: %s", err)
+	}
+
+	dealers, err := s.QueryParticipants(admin, roleDealer)
+	if err != nil || len(dealers) != 3 {
+		t.Fatalf("Expected three dealers, got %+v (%v)", dealers, err)
+	}
+}
+
+func TestDeactivatedSubmittersAreRefused(t *testing.T) {
+	s := new(CarChainCode)
+	stub := newTestStub()
+	manufacturer := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "manufacturer", "participantId": "MOrg01"})
+	dealer := asSubmitter(t, stub, "Org2MSP", map[string]string{"role": "dealer", "participantId": "D101"})
+	owner := asSubmitter(t, stub, "Org3MSP", map[string]string{"role": "consumer", "participantId": "CUST101"})
+	admin := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "admin"})
+
+	for _, carId := range []string{"M201", "M202"} {
+		if _, err := s.CreateCar(manufacturer, CarInput{ManufacturerId: "MOrg01", CarId: carId, CarMake: "2022", CarModel: "MOrg01CM201", CarColor: "Red", ManufacturingDate: "2022-01-01T00:00:00Z"}); err != nil {
+			t.Fatalf("Failed to create car: %s", err)
+		}
+		if err := s.ShipToDealer(manufacturer, carId, "D101", "CARRIER1", 0); err != nil {
+			t.Fatalf("Failed to ship car: %s", err)
+		}
+	}
+	if err := s.ReceiveDelivery(dealer, "M201", 0); err != nil {
+		t.Fatalf("Failed to receive car: %s", err)
+	}
+	if err := s.SellToCustomer(dealer, "M201", "CUST101", 0); err != nil {
+		t.Fatalf("Failed to sell car: %s", err)
+	}
+
+	if err := s.DeactivateParticipant(admin, roleConsumer, "CUST101"); err != nil {
+		t.Fatalf("Failed to deactivate consumer: %s", err)
+	}
+	if err := s.OfferTransfer(owner, "M201", "CUST102", roleConsumer); err == nil {
+		t.Fatal("Expected a deactivated owner to be refused")
+	}
+
+	if err := s.DeactivateParticipant(admin, roleManufacturer, "MOrg01"); err != nil {
+		t.Fatalf("Failed to deactivate manufacturer: %s", err)
+	}
+	if _, err := s.ReportTransitException(manufacturer, "M202", statusDamaged, "Dented door"); err == nil {
+		t.Fatal("Expected a deactivated reporter to be refused")
+	}
+	if _, err := s.ReportTransitException(dealer, "M202", statusDamaged, "Dented door"); err != nil {
+		t.Fatalf("Expected the active dealer to report the damage: %s", err)
+	}
+}
+
+func TestSubmitterMustBelongToParticipantsMSP(t *testing.T) {
+	s := &CarChainCode{roleRules: []RoleRule{
+		{MSPID: "Org1MSP", Attribute: roleAttribute, Value: roleManufacturer, Role: roleManufacturer},
+		{MSPID: "*", Attribute: roleAttribute, Value: roleDealer, Role: roleDealer},
+	}}
+	stub := newTestStub()
+	manufacturer := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "manufacturer", "participantId": "MOrg01"})
+
+	if _, err := s.CreateCar(manufacturer, CarInput{ManufacturerId: "MOrg01", CarId: "M201", CarMake: "2022", CarModel: "MOrg01CM201", CarColor: "Red", ManufacturingDate: "2022-01-01T00:00:00Z"}); err != nil {
+		t.Fatalf("Failed to create car: %s", err)
+	}
+	if err := s.ShipToDealer(manufacturer, "M201", "D101", "CARRIER1", 0); err != nil {
+		t.Fatalf("Failed to ship car: %s", err)
+	}
+
+	foreign := asSubmitter(t, stub, "Org9MSP", map[string]string{"role": "dealer", "participantId": "D101"})
+	if err := s.ReceiveDelivery(foreign, "M201", 0); err == nil {
+		t.Fatal("Expected a certificate from another MSP naming D101 to be refused")
+	}
+	if err := s.ReceiveDelivery(asSubmitter(t, stub, "Org2MSP", map[string]string{"role": "dealer", "participantId": "D101"}), "M201", 0); err != nil {
+		t.Fatalf("Expected D101 from its own MSP to receive the car: %s", err)
+	}
+}
+
+func TestMigrateKeysMovesSeedCarsToCarIdKeys(t *testing.T) {
+	s := new(CarChainCode)
+	stub := newTestStub()
+	legacy, _ := json.Marshal(Car{ManufacturerId: "MOrg01", CarId: "M101", DealerId: "D101", Status: statusSold})
+	if err := stub.PutState("CAR0", legacy); err != nil {
+		t.Fatalf("Failed to seed legacy car: %s", err)
+	}
+
+	admin := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "admin"})
+	if _, err := s.QueryCar(admin, "M101"); err == nil {
+		t.Fatal("Expected the legacy car not to be found by its id before migration")
+	}
+
+	moved, err := s.MigrateKeys(admin)
+	if err != nil || moved != 1 {
+		t.Fatalf("Expected one moved car, got %d (%v)", moved, err)
+	}
+
+	if car, err := s.QueryCar(admin, "M101"); err != nil || car.DocType != carDocType {
+		t.Fatalf("Expected the car under its id, got %+v (%v)", car, err)
+	}
+	if old, _ := stub.GetState("CAR0"); old != nil {
+		t.Fatal("Expected the legacy key to be deleted")
+	}
+	if cars, err := s.QueryCarsByIndex(admin, CarFilter{DealerId: "D101"}); err != nil || len(cars) != 1 || cars[0].Key != "M101" {
+		t.Fatalf("Expected the index to point at the new key, got %+v (%v)", cars, err)
+	}
+
+	if moved, err := s.MigrateKeys(admin); err != nil || moved != 0 {
+		t.Fatalf("Expected a second migration to move nothing, got %d (%v)", moved, err)
+	}
+}
+
+func TestInitLedgerCanBeRerun(t *testing.T) {
+	s := new(CarChainCode)
+	stub := newTestStub()
+	stub.TransientMap = nil
+	ctx := asSubmitter(t, stub, "Org1MSP", nil)
+
+	for i := 0; i < 2; i++ {
+		if err := s.InitLedger(ctx); err != nil {
+			t.Fatalf("InitLedger run %d failed: %s", i+1, err)
+		}
+	}
+
+	if car, err := s.QueryCar(ctx, "M101"); err != nil || car.ConsumerId != "CUST101" {
+		t.Fatalf("Expected seeded car M101, got %+v (%v)", car, err)
+	}
+	if cars, err := s.QueryAllCars(ctx, false); err != nil || len(cars) != 4 {
+		t.Fatalf("Expected four cars, got %d (%v)", len(cars), err)
+	}
+
+	seed, _ := json.Marshal(LedgerSeed{Cars: []Car{{ManufacturerId: "MOrg01", CarId: "M301", Status: statusCreated}}})
+	stub.TransientMap = map[string][]byte{transientSeedKey: seed}
+	if err := s.InitLedger(ctx); err == nil {
+		t.Fatal("Expected a custom seed to require the admin role")
+	}
+
+	admin := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "admin"})
+	if err := s.InitLedger(admin); err != nil {
+		t.Fatalf("Failed to load custom seed: %s", err)
+	}
+	if _, err := s.QueryCar(admin, "M301"); err != nil {
+		t.Fatalf("Expected seeded car M301: %s", err)
+	}
+}
+
+func TestDeliveryIncidentsLeaveTheHappyPath(t *testing.T) {
+	s := new(CarChainCode)
+	stub := newTestStub()
+	manufacturer := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "manufacturer", "participantId": "MOrg01"})
+	otherManufacturer := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "manufacturer", "participantId": "MOrg02"})
+	dealer := asSubmitter(t, stub, "Org2MSP", map[string]string{"role": "dealer", "participantId": "D101"})
+	otherDealer := asSubmitter(t, stub, "Org2MSP", map[string]string{"role": "dealer", "participantId": "D102"})
+
+	for _, carId := range []string{"M201", "M202"} {
+		if _, err := s.CreateCar(manufacturer, CarInput{ManufacturerId: "MOrg01", CarId: carId, CarMake: "2022", CarModel: "MOrg01CM201", CarColor: "Red", ManufacturingDate: "2022-01-01T00:00:00Z"}); err != nil {
+			t.Fatalf("Failed to create car %s: %s", carId, err)
+		}
+		if err := s.ShipToDealer(manufacturer, carId, "D101", "CARRIER1", 0); err != nil {
+			t.Fatalf("Failed to ship car %s: %s", carId, err)
+		}
+	}
+
+	if _, err := s.RejectDelivery(otherDealer, "M201", InspectionReport{Inspector: "D102-QA", Findings: "Scratched door"}, 0); err == nil {
+		t.Fatal("Expected a dealer to be refused rejecting another dealer's delivery")
+	}
+	if _, err := s.RejectDelivery(dealer, "M201", InspectionReport{Inspector: "D101-QA"}, 0); err == nil {
+		t.Fatal("Expected a rejection without findings to be refused")
+	}
+	if _, err := s.RejectDelivery(dealer, "M201", InspectionReport{Inspector: "D101-QA", Findings: "Scratched door"}, 0); err != nil {
+		t.Fatalf("Failed to reject delivery: %s", err)
+	}
+	if car, _ := s.QueryCar(dealer, "M201"); car.Status != statusDeliveryRejected || car.DealerId != "" {
+		t.Fatalf("Expected the rejected car back with the manufacturer, got %+v", car)
+	}
+	if err := s.ShipToDealer(manufacturer, "M201", "D102", "CARRIER1", 0); err != nil {
+		t.Fatalf("Expected a rejected car to be shipped again: %s", err)
+	}
+	if err := s.ReceiveDelivery(dealer, "M201", 0); err == nil {
+		t.Fatal("Expected a dealer to be refused a car shipped to another dealer")
+	}
+
+	if _, err := s.ReportTransitException(manufacturer, "M202", statusSold, "Gone"); err == nil {
+		t.Fatal("Expected a status other than DAMAGED or LOST to be refused")
+	}
+	if _, err := s.ReportTransitException(otherManufacturer, "M202", statusLost, "Truck missing"); err == nil {
+		t.Fatal("Expected a manufacturer to be refused reporting another manufacturer's car lost")
+	}
+	if _, err := s.ReportTransitException(manufacturer, "M202", statusLost, "Truck missing"); err != nil {
+		t.Fatalf("Failed to report lost car: %s", err)
+	}
+	var transitionErr *TransitionError
+	if err := s.ReceiveDelivery(dealer, "M202", 0); !errors.As(err, &transitionErr) {
+		t.Fatalf("Expected a lost car not to be received, got %v", err)
+	}
+
+	if err := s.ReceiveDelivery(otherDealer, "M201", 0); err != nil {
+		t.Fatalf("Failed to receive car: %s", err)
+	}
+	if err := s.SellToCustomer(dealer, "M201", "CUST101", 0); err == nil {
+		t.Fatal("Expected a dealer to be refused selling another dealer's car")
+	}
+	stub.MockTransactionStart("tx2")
+	if _, err := s.ReturnToManufacturer(dealer, "M201", "Unsold", 0); err == nil {
+		t.Fatal("Expected a dealer to be refused returning another dealer's car")
+	}
+	if _, err := s.ReturnToManufacturer(otherDealer, "M201", "Unsold", 0); err != nil {
+		t.Fatalf("Failed to return car: %s", err)
+	}
+
+	incidents, err := s.QueryDeliveryIncidents(dealer, "M201")
+	if err != nil || len(incidents) != 2 || incidents[0].Status != statusDeliveryRejected || incidents[0].Inspection == nil || incidents[1].Status != statusReturned {
+		t.Fatalf("Unexpected incidents %+v (%v)", incidents, err)
+	}
+}
+
+func TestCarrierTracksShipmentCheckpoints(t *testing.T) {
+	s := new(CarChainCode)
+	stub := newTestStub()
+	manufacturer := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "manufacturer", "participantId": "MOrg01"})
+	carrier := asSubmitter(t, stub, "Org4MSP", map[string]string{"role": "carrier", "participantId": "CARRIER1"})
+
+	if _, err := s.CreateCar(manufacturer, CarInput{ManufacturerId: "MOrg01", CarId: "M201", CarMake: "2022", CarModel: "MOrg01CM201", CarColor: "Red", ManufacturingDate: "2022-01-01T00:00:00Z"}); err != nil {
+		t.Fatalf("Failed to create car: %s", err)
+	}
+	if _, err := s.RecordCheckpoint(carrier, CheckpointInput{CarId: "M201", Leg: 1, Location: "Plant", Timestamp: "2022-02-01T08:00:00Z"}); err == nil {
+		t.Fatal("Expected a checkpoint on an unshipped car to be refused")
+	}
+	if err := s.ShipToDealer(manufacturer, "M201", "D101", "CARRIER1", 0); err != nil {
+		t.Fatalf("Failed to ship car: %s", err)
+	}
+
+	if _, err := s.RecordCheckpoint(manufacturer, CheckpointInput{CarId: "M201", Leg: 1, Location: "Plant", Timestamp: "2022-02-01T08:00:00Z"}); err == nil {
+		t.Fatal("Expected RecordCheckpoint to require the carrier role")
+	}
+	nextCarrier := asSubmitter(t, stub, "Org4MSP", map[string]string{"role": "carrier", "participantId": "CARRIER2"})
+	if _, err := s.RecordCheckpoint(nextCarrier, CheckpointInput{CarId: "M201", Leg: 1, Location: "Plant", Timestamp: "2022-02-01T08:00:00Z"}); err == nil {
+		t.Fatal("Expected a carrier the shipment was not handed to to be refused")
+	}
+
+	checkpoints := []CheckpointInput{
+		{CarId: "M201", Leg: 1, Location: "Plant", Timestamp: "2022-02-01T08:00:00Z", HandoverTo: "CARRIER1"},
+		{CarId: "M201", Leg: 2, Location: "Port", Timestamp: "2022-02-03T10:00:00Z", HandoverTo: "CARRIER2"},
+	}
+	for i, checkpoint := range checkpoints {
+		stub.MockTransactionStart(fmt.Sprintf("track%d", i))
+		if _, err := s.RecordCheckpoint(carrier, checkpoint); err != nil {
+			t.Fatalf("Failed to record checkpoint %d: %s", i, err)
+		}
+	}
+
+	stub.MockTransactionStart("track-handed-over")
+	if _, err := s.RecordCheckpoint(carrier, CheckpointInput{CarId: "M201", Leg: 3, Location: "Depot", Timestamp: "2022-02-04T08:00:00Z"}); err == nil {
+		t.Fatal("Expected the carrier that handed the shipment over to be refused")
+	}
+
+	stub.MockTransactionStart("track-back")
+	if _, err := s.RecordCheckpoint(nextCarrier, CheckpointInput{CarId: "M201", Leg: 1, Location: "Plant", Timestamp: "2022-02-04T08:00:00Z"}); err == nil {
+		t.Fatal("Expected a checkpoint on an earlier leg to be refused")
+	}
+
+	stub.MockTransactionStart("track-next")
+	if _, err := s.RecordCheckpoint(nextCarrier, CheckpointInput{CarId: "M201", Leg: 3, Location: "Depot", Timestamp: "2022-02-04T08:00:00Z"}); err != nil {
+		t.Fatalf("Expected the carrier the shipment was handed to to record a checkpoint: %s", err)
+	}
+
+	tracking, err := s.QueryTracking(carrier, "M201")
+	if err != nil || len(tracking) != 3 || tracking[0].Location != "Plant" || tracking[1].CarrierId != "CARRIER1" || tracking[2].CarrierId != "CARRIER2" {
+		t.Fatalf("Unexpected tracking %+v (%v)", tracking, err)
+	}
+}
+
+func TestShipAndSellSettleTokens(t *testing.T) {
+	t.Setenv("CORE_PEER_LOCALMSPID", "Org2MSP")
+	s := new(CarChainCode)
+	stub := newTestStub()
+	manufacturer := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "manufacturer", "participantId": "MOrg01"})
+	dealer := asSubmitter(t, stub, "Org2MSP", map[string]string{"role": "dealer", "participantId": "D101"})
+	consumer := asSubmitter(t, stub, "Org3MSP", map[string]string{"role": "consumer", "participantId": "CUST101"})
+	admin := asSubmitter(t, stub, "Org2MSP", map[string]string{"role": "admin"})
+
+	if _, err := s.CreateCar(manufacturer, CarInput{ManufacturerId: "MOrg01", CarId: "M201", CarMake: "2022", CarModel: "MOrg01CM201", CarColor: "Red", ManufacturingDate: "2022-01-01T00:00:00Z"}); err != nil {
+		t.Fatalf("Failed to create car: %s", err)
+	}
+
+	if err := s.Approve(dealer, roleManufacturer, "MOrg01", 100); err != nil {
+		t.Fatalf("Failed to approve: %s", err)
+	}
+	if err := s.ShipToDealer(manufacturer, "M201", "D101", "CARRIER1", 0); err == nil {
+		t.Fatal("Expected shipping beyond the dealer's allowance to be refused")
+	}
+
+	if err := s.Approve(dealer, roleManufacturer, "MOrg01", 360000); err != nil {
+		t.Fatalf("Failed to approve: %s", err)
+	}
+	if err := s.ShipToDealer(manufacturer, "M201", "D101", "CARRIER1", 0); err != nil {
+		t.Fatalf("Failed to ship car: %s", err)
+	}
+	if balance, _ := s.BalanceOf(admin, roleManufacturer, "MOrg01"); balance != 360000 {
+		t.Fatalf("Expected the manufacturer to be paid 360000 at ship time, got %d", balance)
+	}
+	if allowance, _ := s.Allowance(dealer, roleDealer, "D101", roleManufacturer, "MOrg01"); allowance != 0 {
+		t.Fatalf("Expected the allowance to be used up, got %d", allowance)
+	}
+
+	if err := s.ReceiveDelivery(dealer, "M201", 0); err != nil {
+		t.Fatalf("Failed to receive car: %s", err)
+	}
+	if err := s.SellToCustomer(dealer, "M201", "CUST101", 0); err != nil {
+		t.Fatalf("Failed to sell car: %s", err)
+	}
+	if balance, _ := s.BalanceOf(dealer, roleDealer, "D101"); balance != 10000000-360000+550000 {
+		t.Fatalf("Expected the dealer to hold one balance for both payments, got %d", balance)
+	}
+	if balance, _ := s.BalanceOf(admin, roleConsumer, "CUST101"); balance != 10000000-550000 {
+		t.Fatalf("Unexpected consumer balance %d", balance)
+	}
+	if allowance, err := s.Allowance(dealer, roleConsumer, "CUST101", roleDealer, "D101"); err != nil || allowance != 10000000-550000 {
+		t.Fatalf("Expected the spender to read its allowance, got %d (%v)", allowance, err)
+	}
+
+	otherDealer := asSubmitter(t, stub, "Org2MSP", map[string]string{"role": "dealer", "participantId": "D102"})
+	if _, err := s.BalanceOf(otherDealer, roleDealer, "D101"); err == nil {
+		t.Fatal("Expected another dealer's balance to be refused")
+	}
+	if _, err := s.BalanceOf(dealer, roleManufacturer, "MOrg01"); err == nil {
+		t.Fatal("Expected the manufacturer's balance to be refused to the dealer")
+	}
+	if _, err := s.Allowance(otherDealer, roleConsumer, "CUST101", roleDealer, "D101"); err == nil {
+		t.Fatal("Expected an allowance to be refused to a participant who neither granted nor holds it")
+	}
+
+	if err := s.Transfer(consumer, roleConsumer, "CUST102", 20000000); err == nil {
+		t.Fatal("Expected a transfer beyond the balance to be refused")
+	}
+	if err := s.Transfer(consumer, roleConsumer, "CUST102", 1000); err != nil {
+		t.Fatalf("Failed to transfer: %s", err)
+	}
+	if err := s.Mint(consumer, roleConsumer, "CUST101", 1000); err == nil {
+		t.Fatal("Expected Mint to require the admin role")
+	}
+
+	if _, err := s.BalanceOf(consumer, roleConsumer, "CUST101"); err == nil {
+		t.Fatal("Expected balances to be refused to submitters outside the peer's organization")
+	}
+	for key := range stub.State {
+		if strings.Contains(key, tokenAccountType) || strings.Contains(key, tokenAllowanceType) {
+			t.Fatalf("Expected no token record in the world state, found %q", key)
+		}
+	}
+}
+
+func TestRefusedShipmentsRefundTheDealer(t *testing.T) {
+	t.Setenv("CORE_PEER_LOCALMSPID", "Org2MSP")
+	s := new(CarChainCode)
+	stub := newTestStub()
+	manufacturer := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "manufacturer", "participantId": "MOrg01"})
+	dealer := asSubmitter(t, stub, "Org2MSP", map[string]string{"role": "dealer", "participantId": "D101"})
+	admin := asSubmitter(t, stub, "Org2MSP", map[string]string{"role": "admin"})
+	balances := func(wantDealer int, wantManufacturer int) {
+		t.Helper()
+		dealerBalance, _ := s.BalanceOf(admin, roleDealer, "D101")
+		manufacturerBalance, _ := s.BalanceOf(admin, roleManufacturer, "MOrg01")
+		if dealerBalance != wantDealer || manufacturerBalance != wantManufacturer {
+			t.Fatalf("Expected balances %d and %d, got %d and %d", wantDealer, wantManufacturer, dealerBalance, manufacturerBalance)
+		}
+	}
+
+	for _, carId := range []string{"M201", "M202", "M203"} {
+		if _, err := s.CreateCar(manufacturer, CarInput{ManufacturerId: "MOrg01", CarId: carId, CarMake: "2022", CarModel: "MOrg01CM201", CarColor: "Red", ManufacturingDate: "2022-01-01T00:00:00Z"}); err != nil {
+			t.Fatalf("Failed to create car %s: %s", carId, err)
+		}
+		if err := s.ShipToDealer(manufacturer, carId, "D101", "CARRIER1", 0); err != nil {
+			t.Fatalf("Failed to ship car %s: %s", carId, err)
+		}
+	}
+	balances(10000000-3*360000, 3*360000)
+
+	if _, err := s.RejectDelivery(dealer, "M201", InspectionReport{Inspector: "D101-QA", Findings: "Scratched door"}, 0); err != nil {
+		t.Fatalf("Failed to reject delivery: %s", err)
+	}
+	balances(10000000-2*360000, 2*360000)
+
+	if _, err := s.ReportTransitException(manufacturer, "M202", statusDamaged, "Dropped at the port"); err != nil {
+		t.Fatalf("Failed to report damaged car: %s", err)
+	}
+	balances(10000000-360000, 360000)
+
+	if err := s.ReceiveDelivery(dealer, "M203", 0); err != nil {
+		t.Fatalf("Failed to receive car: %s", err)
+	}
+	balances(10000000-360000, 360000)
+	if _, err := s.ReturnToManufacturer(dealer, "M203", "Unsold", 0); err != nil {
+		t.Fatalf("Failed to return car: %s", err)
+	}
+	balances(10000000, 0)
+
+	if err := s.ShipToDealer(manufacturer, "M201", "D101", "CARRIER1", 0); err != nil {
+		t.Fatalf("Failed to ship the rejected car again: %s", err)
+	}
+	balances(10000000-360000, 360000)
+	if err := s.ReceiveDelivery(dealer, "M201", 0); err != nil {
+		t.Fatalf("Failed to receive car: %s", err)
+	}
+	balances(10000000-360000, 360000)
+}
+
+func TestSalesReportsSumPrivatePrices(t *testing.T) {
+	t.Setenv("CORE_PEER_LOCALMSPID", "Org2MSP")
+	s := new(CarChainCode)
+	stub := newTestStub()
+	manufacturer := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "manufacturer", "participantId": "MOrg01"})
+	dealer := asSubmitter(t, stub, "Org2MSP", map[string]string{"role": "dealer", "participantId": "D101"})
+	admin := asSubmitter(t, stub, "Org2MSP", map[string]string{"role": "admin"})
+
+	for _, carId := range []string{"M201", "M202"} {
+		if _, err := s.CreateCar(manufacturer, CarInput{ManufacturerId: "MOrg01", CarId: carId, CarMake: "2022", CarModel: "MOrg01CM201", CarColor: "Red", ManufacturingDate: "2022-01-01T00:00:00Z"}); err != nil {
+			t.Fatalf("Failed to create car %s: %s", carId, err)
+		}
+		if err := s.ShipToDealer(manufacturer, carId, "D101", "CARRIER1", 0); err != nil {
+			t.Fatalf("Failed to ship car %s: %s", carId, err)
+		}
+	}
+	if err := s.ReceiveDelivery(dealer, "M201", 0); err != nil {
+		t.Fatalf("Failed to receive car: %s", err)
+	}
+	if err := s.SellToCustomer(dealer, "M201", "CUST101", 0); err != nil {
+		t.Fatalf("Failed to sell car: %s", err)
+	}
+
+	if _, err := s.QuerySalesByDealer(dealer, "", ""); err == nil {
+		t.Fatal("Expected sales reports to require the admin role")
+	}
+
+	report, err := s.QuerySalesByDealer(admin, "2000-01-01T00:00:00Z", "")
+	if err != nil || len(report) != 1 {
+		t.Fatalf("Expected one dealer group, got %+v (%v)", report, err)
+	}
+	want := SalesAggregate{Group: "D101", ShippedCount: 2, SoldCount: 1, ManufacturerPrice: 700000, ShippingPrice: 20000, CustomerPrice: 550000, DealerMargin: 190000}
+	if report[0] != want {
+		t.Fatalf("Expected %+v, got %+v", want, report[0])
+	}
+
+	if report, err := s.QuerySalesByModel(admin, "2999-01-01T00:00:00Z", ""); err != nil || len(report) != 0 {
+		t.Fatalf("Expected no cars after the range start, got %+v (%v)", report, err)
+	}
+}
+
+func TestSalesReportsCountEachSaleOfAResoldCar(t *testing.T) {
+	t.Setenv("CORE_PEER_LOCALMSPID", "Org2MSP")
+	s := new(CarChainCode)
+	stub := newTestStub()
+	manufacturer := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "manufacturer", "participantId": "MOrg01"})
+	dealer := asSubmitter(t, stub, "Org2MSP", map[string]string{"role": "dealer", "participantId": "D101"})
+	secondDealer := asSubmitter(t, stub, "Org2MSP", map[string]string{"role": "dealer", "participantId": "D102"})
+	owner := asSubmitter(t, stub, "Org3MSP", map[string]string{"role": "consumer", "participantId": "CUST101"})
+	admin := asSubmitter(t, stub, "Org2MSP", map[string]string{"role": "admin"})
+
+	if _, err := s.CreateCar(manufacturer, CarInput{ManufacturerId: "MOrg01", CarId: "M201", CarMake: "2022", CarModel: "MOrg01CM201", CarColor: "Red", ManufacturingDate: "2022-01-01T00:00:00Z"}); err != nil {
+		t.Fatalf("Failed to create car: %s", err)
+	}
+	if err := s.ShipToDealer(manufacturer, "M201", "D101", "CARRIER1", 0); err != nil {
+		t.Fatalf("Failed to ship car: %s", err)
+	}
+	if err := s.ReceiveDelivery(dealer, "M201", 0); err != nil {
+		t.Fatalf("Failed to receive car: %s", err)
+	}
+	if err := s.SellToCustomer(dealer, "M201", "CUST101", 0); err != nil {
+		t.Fatalf("Failed to sell car: %s", err)
+	}
+
+	stub.MockTransactionStart("trade-in")
+	if err := s.OfferTransfer(owner, "M201", "D102", roleDealer); err != nil {
+		t.Fatalf("Failed to offer trade-in: %s", err)
+	}
+	if err := s.AcceptTransfer(secondDealer, "M201", 0); err != nil {
+		t.Fatalf("Failed to accept trade-in: %s", err)
+	}
+
+	stub.MockTransactionStart("resale")
+	withPrices(stub, CarPrices{CustomerPrice: 400000, Salt: "resale-salt"})
+	if err := s.SellToCustomer(secondDealer, "M201", "CUST102", 0); err != nil {
+		t.Fatalf("Failed to resell car: %s", err)
+	}
+
+	if price, err := s.QueryDealerConsumerPrice(secondDealer, "M201"); err != nil || price.CustomerPrice != 400000 || !price.Resale {
+		t.Fatalf("Expected the latest sale's price, got %+v (%v)", price, err)
+	}
+
+	report, err := s.QuerySalesByDealer(admin, "", "")
+	if err != nil || len(report) != 2 {
+		t.Fatalf("Expected two dealer groups, got %+v (%v)", report, err)
+	}
+	want := []SalesAggregate{
+		{Group: "D101", ShippedCount: 1, SoldCount: 1, ManufacturerPrice: 350000, ShippingPrice: 10000, CustomerPrice: 550000, DealerMargin: 190000},
+		{Group: "D102", SoldCount: 1, CustomerPrice: 400000, DealerMargin: 400000},
+	}
+	for i := range want {
+		if report[i] != want[i] {
+			t.Fatalf("Expected %+v, got %+v", want[i], report[i])
+		}
+	}
+
+	if report, err := s.QuerySalesByModel(admin, "", ""); err != nil || len(report) != 1 || report[0].SoldCount != 2 || report[0].CustomerPrice != 950000 {
+		t.Fatalf("Expected both sales in the model group, got %+v (%v)", report, err)
+	}
+}
+
+func TestCreateCarsBatchIsAllOrNothing(t *testing.T) {
+	s := &CarChainCode{maxBatchSize: 3}
+	stub := newTestStub()
+	manufacturer := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "manufacturer", "participantId": "MOrg01"})
+	batchPrices := func(count int) {
+		prices := make([]CarPrices, count)
+		for i := range prices {
+			prices[i] = CarPrices{ManufacturerPrice: 350000, Salt: fmt.Sprintf("batch-salt-%d", i)}
+		}
+		pricesAsBytes, _ := json.Marshal(prices)
+		stub.TransientMap = map[string][]byte{transientPricesKey: pricesAsBytes}
+	}
+	input := func(carId string) CarInput {
+		return CarInput{ManufacturerId: "MOrg01", CarId: carId, CarMake: "2022", CarModel: "MOrg01CM201", CarColor: "Red", ManufacturingDate: "2022-01-01T00:00:00Z"}
+	}
+
+	batchPrices(4)
+	if _, err := s.CreateCarsBatch(manufacturer, []CarInput{input("M201"), input("M202"), input("M203"), input("M204")}); err == nil {
+		t.Fatal("Expected a batch over the maximum size to be refused")
+	}
+
+	batchPrices(3)
+	bad := input("M203")
+	bad.ManufacturingDate = "2022/01/01"
+	if _, err := s.CreateCarsBatch(manufacturer, []CarInput{input("M201"), input("M201"), bad}); err == nil {
+		t.Fatal("Expected a batch with duplicate and invalid entries to be refused")
+	}
+	if _, err := s.QueryCar(manufacturer, "M201"); err == nil {
+		t.Fatal("Expected no car of a rejected batch to be created")
+	}
+
+	foreign := input("M203")
+	foreign.ManufacturerId = "MOrg02"
+	if _, err := s.CreateCarsBatch(manufacturer, []CarInput{input("M201"), input("M202"), foreign}); err == nil {
+		t.Fatal("Expected a batch with another manufacturer's car to be refused")
+	}
+
+	results, err := s.CreateCarsBatch(manufacturer, []CarInput{input("M201"), input("M202"), input("M203")})
+	if err != nil || len(results) != 3 || results[2].CarId != "M203" || results[2].Status != statusCreated {
+		t.Fatalf("Unexpected batch results %+v (%v)", results, err)
+	}
+
+	// the mock stub queues every event, while a peer keeps only the transaction's last one
+	last := ""
+	for len(stub.ChaincodeEventsChannel) > 0 {
+		last = (<-stub.ChaincodeEventsChannel).EventName
+	}
+	if last != eventCarsCreated {
+		t.Fatalf("Expected the batch to end with a %s event, got %s", eventCarsCreated, last)
+	}
+}
+
+func TestCarRecordsUpgradeLazily(t *testing.T) {
+	s := new(CarChainCode)
+	stub := newTestStub()
+	legacy, _ := json.Marshal(Car{ManufacturerId: "MOrg01", CarId: "M101", ConsumerId: "CUST101", Status: statusSold, SoldOnDate: "2022-04-20T00:00:00Z"})
+	putTestAsset(stub, carKeyType, []string{"M101"}, json.RawMessage(legacy))
+
+	admin := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "admin"})
+	car, err := s.QueryCar(admin, "M101")
+	if err != nil || car.SchemaVersion != currentSchemaVersion || car.DocType != carDocType || len(car.Owners) != 1 || car.Owners[0].OwnerId != "CUST101" {
+		t.Fatalf("Expected the car upgraded on read, got %+v (%v)", car, err)
+	}
+
+	key, _ := stub.CreateCompositeKey(carKeyType, []string{"M101"})
+	stored, _ := stub.GetState(key)
+	if bytes.Contains(stored, []byte(fmt.Sprintf("schemaVersion\":%d", currentSchemaVersion))) {
+		t.Fatal("Expected a read not to rewrite the stored record")
+	}
+
+	future, _ := json.Marshal(Car{CarId: "M102", SchemaVersion: currentSchemaVersion + 1})
+	putTestAsset(stub, carKeyType, []string{"M102"}, json.RawMessage(future))
+	if _, err := s.QueryCar(admin, "M102"); err == nil {
+		t.Fatal("Expected a record from a newer schema version to be refused")
+	}
+
+	if _, err := s.MigrateAll(asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "dealer"}), 10, ""); err == nil {
+		t.Fatal("Expected MigrateAll to require the admin role")
+	}
+}
+
+func TestMigrateAllRewritesLegacyCarsPageByPage(t *testing.T) {
+	s := new(CarChainCode)
+	stub := newTestStub()
+	for _, carId := range []string{"M101", "M102", "M103"} {
+		legacy, _ := json.Marshal(Car{ManufacturerId: "MOrg01", CarId: carId, ConsumerId: "CUST101", Status: statusSold, SoldOnDate: "2022-04-20T00:00:00Z"})
+		putTestAsset(stub, carKeyType, []string{carId}, json.RawMessage(legacy))
+	}
+	current, _ := json.Marshal(Car{DocType: carDocType, SchemaVersion: currentSchemaVersion, ManufacturerId: "MOrg01", CarId: "M104", Status: statusCreated})
+	putTestAsset(stub, carKeyType, []string{"M104"}, json.RawMessage(current))
+
+	admin := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "admin"})
+	migrated, pages, bookmark := 0, 0, ""
+	for {
+		result, err := s.MigrateAll(admin, 2, bookmark)
+		if err != nil {
+			t.Fatalf("Expected MigrateAll to succeed, got %v", err)
+		}
+		if result.FetchedRecordsCount > 2 {
+			t.Fatalf("Expected at most 2 cars per page, got %d", result.FetchedRecordsCount)
+		}
+		migrated += result.Migrated
+		pages++
+		bookmark = result.Bookmark
+		if bookmark == "" {
+			break
+		}
+		if bookmark != "M103" {
+			t.Fatalf("Expected the first page to end before M103, got bookmark %q", bookmark)
+		}
+	}
+
+	if pages != 2 || migrated != 3 {
+		t.Fatalf("Expected 3 legacy cars migrated over 2 pages, got %d over %d", migrated, pages)
+	}
+
+	for _, carId := range []string{"M101", "M102", "M103", "M104"} {
+		key, _ := stub.CreateCompositeKey(carKeyType, []string{carId})
+		stored, _ := stub.GetState(key)
+		car := new(Car)
+		if err := json.Unmarshal(stored, car); err != nil || car.SchemaVersion != currentSchemaVersion || car.DocType != carDocType {
+			t.Fatalf("Expected %s stored at schema version %d, got %s", carId, currentSchemaVersion, stored)
+		}
+	}
+}
+
+func TestScrappedCarIsTerminal(t *testing.T) {
+	s := new(CarChainCode)
+	stub := newTestStub()
+	manufacturer := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "manufacturer", "participantId": "MOrg01"})
+	dealer := asSubmitter(t, stub, "Org2MSP", map[string]string{"role": "dealer", "participantId": "D101"})
+	recycler := asSubmitter(t, stub, "Org4MSP", map[string]string{"role": "recycler"})
+	certificateHash := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+	for _, carId := range []string{"M201", "M202"} {
+		if _, err := s.CreateCar(manufacturer, CarInput{ManufacturerId: "MOrg01", CarId: carId, CarMake: "2022", CarModel: "MOrg01CM201", CarColor: "Red", ManufacturingDate: "2022-01-01T00:00:00Z"}); err != nil {
+			t.Fatalf("Failed to create car %s: %s", carId, err)
+		}
+	}
+
+	if err := s.ScrapCar(dealer, "M201", certificateHash, 0); err == nil {
+		t.Fatal("Expected ScrapCar to require the recycler or regulator role")
+	}
+	if err := s.ScrapCar(recycler, "M201", "not-a-hash", 0); err == nil {
+		t.Fatal("Expected a malformed certificate hash to be refused")
+	}
+	stub.MockTransactionStart("tx2")
+	if err := s.ScrapCar(recycler, "M201", certificateHash, 0); err != nil {
+		t.Fatalf("Failed to scrap car: %s", err)
+	}
+
+	car, err := s.QueryCar(recycler, "M201")
+	if err != nil || car.Status != statusScrapped || car.DestructionCertificateHash != certificateHash || car.ScrappedOnDate == "" {
+		t.Fatalf("Expected a scrapped car with its certificate, got %+v (%v)", car, err)
+	}
+
+	var transitionErr *TransitionError
+	if err := s.ShipToDealer(manufacturer, "M201", "D101", "CARRIER1", 0); !errors.As(err, &transitionErr) {
+		t.Fatalf("Expected a scrapped car not to be shipped, got %v", err)
+	}
+	if err := s.ScrapCar(recycler, "M201", certificateHash, 0); !errors.As(err, &transitionErr) {
+		t.Fatalf("Expected a scrapped car not to be scrapped again, got %v", err)
+	}
+
+	if cars, err := s.QueryAllCars(recycler, false); err != nil || len(cars) != 1 || cars[0].Key != "M202" {
+		t.Fatalf("Expected only M202 without scrapped cars, got %+v (%v)", cars, err)
+	}
+	if cars, err := s.QueryAllCars(recycler, true); err != nil || len(cars) != 2 {
+		t.Fatalf("Expected both cars with scrapped cars, got %d (%v)", len(cars), err)
+	}
+}
+
+func TestWarrantyStartsOnSaleAndClaimsAreDecided(t *testing.T) {
+	s := new(CarChainCode)
+	stub := newTestStub()
+	manufacturer := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "manufacturer", "participantId": "MOrg01"})
+	dealer := asSubmitter(t, stub, "Org2MSP", map[string]string{"role": "dealer", "participantId": "D101"})
+
+	if _, err := s.SetWarrantyPolicy(manufacturer, WarrantyPolicyInput{CarModel: "MOrg01CM201", TermMonths: 0, MaxMileage: 60000}); err == nil {
+		t.Fatal("Expected a policy without a term to be refused")
+	}
+	if _, err := s.SetWarrantyPolicy(manufacturer, WarrantyPolicyInput{CarModel: "MOrg01CM201", TermMonths: 36, MaxMileage: 60000}); err != nil {
+		t.Fatalf("Failed to set warranty policy: %s", err)
+	}
+
+	if _, err := s.CreateCar(manufacturer, CarInput{ManufacturerId: "MOrg01", CarId: "M201", CarMake: "2022", CarModel: "MOrg01CM201", CarColor: "Red", ManufacturingDate: "2022-01-01T00:00:00Z"}); err != nil {
+		t.Fatalf("Failed to create car: %s", err)
+	}
+	if err := s.ShipToDealer(manufacturer, "M201", "D101", "CARRIER1", 0); err != nil {
+		t.Fatalf("Failed to ship car: %s", err)
+	}
+	if err := s.ReceiveDelivery(dealer, "M201", 0); err != nil {
+		t.Fatalf("Failed to receive car: %s", err)
+	}
+	if _, err := s.FileWarrantyClaim(dealer, WarrantyClaimInput{CarId: "M201", Description: "Gearbox", Odometer: 10, Amount: 1200}); err == nil {
+		t.Fatal("Expected a claim on an unsold car to be refused")
+	}
+	if err := s.SellToCustomer(dealer, "M201", "CUST101", 0); err != nil {
+		t.Fatalf("Failed to sell car: %s", err)
+	}
+
+	warranty, err := s.QueryWarranty(dealer, "M201")
+	if err != nil || warranty.StartDate == "" || !dateBefore(warranty.StartDate, warranty.EndDate) || warranty.MaxMileage != 60000 {
+		t.Fatalf("Expected the sale to start a warranty, got %+v (%v)", warranty, err)
+	}
+
+	if _, err := s.FileWarrantyClaim(dealer, WarrantyClaimInput{CarId: "M201", Description: "Gearbox", Odometer: 70000, Amount: 1200}); err == nil {
+		t.Fatal("Expected a claim beyond the warranty mileage to be refused")
+	}
+	stub.MockTransactionStart("claim1")
+	claim, err := s.FileWarrantyClaim(dealer, WarrantyClaimInput{CarId: "M201", Description: "Gearbox", Odometer: 12000, Amount: 1200})
+	if err != nil || claim.Status != claimFiled || claim.ManufacturerId != "MOrg01" {
+		t.Fatalf("Expected a filed claim, got %+v (%v)", claim, err)
+	}
+
+	other := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "manufacturer", "participantId": "MOrg02"})
+	if _, err := s.ApproveWarrantyClaim(other, "M201", claim.ClaimId); err == nil {
+		t.Fatal("Expected another manufacturer not to decide the claim")
+	}
+	if _, err := s.RejectWarrantyClaim(manufacturer, "M201", claim.ClaimId, ""); err == nil {
+		t.Fatal("Expected a rejection without a reason to be refused")
+	}
+	if claim, err := s.ApproveWarrantyClaim(manufacturer, "M201", claim.ClaimId); err != nil || claim.Status != claimApproved {
+		t.Fatalf("Expected the claim approved, got %+v (%v)", claim, err)
+	}
+	if _, err := s.RejectWarrantyClaim(manufacturer, "M201", claim.ClaimId, "Wear and tear"); err == nil {
+		t.Fatal("Expected a decided claim not to be decided again")
+	}
+
+	claims, err := s.QueryWarrantyClaims(dealer, "M201")
+	if err != nil || len(claims) != 1 || claims[0].Status != claimApproved {
+		t.Fatalf("Unexpected claims %+v (%v)", claims, err)
+	}
+
+	stub.MockTransactionStart("claim2")
+	if _, err := s.FileWarrantyClaim(dealer, WarrantyClaimInput{CarId: "M201", Description: "Brakes", Odometer: 500, Amount: 300}); err != nil {
+		t.Fatalf("Failed to file claim: %s", err)
+	}
+	if car, _ := s.QueryCar(dealer, "M201"); car.Odometer != 12000 || !car.OdometerRollback {
+		t.Fatalf("Expected the claim's low reading to flag the car, got %+v", car)
+	}
+
+	stub.MockTransactionStart("reading1")
+	if _, err := s.RecordOdometer(dealer, "M201", 65000); err != nil {
+		t.Fatalf("Failed to record odometer: %s", err)
+	}
+	stub.MockTransactionStart("claim3")
+	if _, err := s.FileWarrantyClaim(dealer, WarrantyClaimInput{CarId: "M201", Description: "Clutch", Odometer: 100, Amount: 900}); err == nil {
+		t.Fatal("Expected the car's odometer beyond the warranty mileage to refuse a claim with a low reading")
+	}
+}
+
+func TestOdometerRollbackIsFlagged(t *testing.T) {
+	s := new(CarChainCode)
+	stub := newTestStub()
+	manufacturer := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "manufacturer", "participantId": "MOrg01"})
+	dealer := asSubmitter(t, stub, "Org2MSP", map[string]string{"role": "dealer", "participantId": "D101"})
+	serviceCenter := asSubmitter(t, stub, "Org4MSP", map[string]string{"role": "servicecenter", "participantId": "SC1"})
+	regulator := asSubmitter(t, stub, "Org4MSP", map[string]string{"role": "regulator"})
+
+	if _, err := s.CreateCar(manufacturer, CarInput{ManufacturerId: "MOrg01", CarId: "M201", CarMake: "2022", CarModel: "MOrg01CM201", CarColor: "Red", ManufacturingDate: "2022-01-01T00:00:00Z"}); err != nil {
+		t.Fatalf("Failed to create car: %s", err)
+	}
+	stub.MockTransactionStart("odo1")
+	if err := s.ShipToDealer(manufacturer, "M201", "D101", "CARRIER1", -1); err == nil {
+		t.Fatal("Expected a negative odometer reading to be refused")
+	}
+	if err := s.ShipToDealer(manufacturer, "M201", "D101", "CARRIER1", 5); err != nil {
+		t.Fatalf("Failed to ship car: %s", err)
+	}
+	stub.MockTransactionStart("odo2")
+	if err := s.ReceiveDelivery(dealer, "M201", 40); err != nil {
+		t.Fatalf("Failed to receive car: %s", err)
+	}
+	stub.MockTransactionStart("odo3")
+	if err := s.SellToCustomer(dealer, "M201", "CUST101", 30); err == nil {
+		t.Fatal("Expected a life cycle transition with a lower reading to be refused")
+	}
+	if err := s.SellToCustomer(dealer, "M201", "CUST101", 45); err != nil {
+		t.Fatalf("Failed to sell car: %s", err)
+	}
+	stub.MockTransactionStart("odo4")
+	if _, err := s.AddServiceRecord(serviceCenter, ServiceRecordInput{CarId: "M201", ServiceDate: "2023-01-10T00:00:00Z", Odometer: 12000, WorkPerformed: "Oil change", Cost: 150}); err != nil {
+		t.Fatalf("Failed to add service record: %s", err)
+	}
+
+	if flagged, err := s.QueryOdometerFlaggedCars(regulator); err != nil || len(flagged) != 0 {
+		t.Fatalf("Expected no flagged cars, got %+v (%v)", flagged, err)
+	}
+
+	if _, err := s.RecordOdometer(manufacturer, "M201", 8000); err == nil {
+		t.Fatal("Expected RecordOdometer to refuse the manufacturer role")
+	}
+	stub.MockTransactionStart("odo5")
+	reading, err := s.RecordOdometer(regulator, "M201", 8000)
+	if err != nil || !reading.Rollback {
+		t.Fatalf("Expected the lower reading to be stored as a rollback, got %+v (%v)", reading, err)
+	}
+
+	car, _ := s.QueryCar(regulator, "M201")
+	if car.Odometer != 12000 || !car.OdometerRollback {
+		t.Fatalf("Expected the car to keep its highest reading and be flagged, got %+v", car)
+	}
+	if flagged, err := s.QueryOdometerFlaggedCars(regulator); err != nil || len(flagged) != 1 || flagged[0].Key != "M201" {
+		t.Fatalf("Expected M201 flagged, got %+v (%v)", flagged, err)
+	}
+
+	readings, err := s.QueryOdometerReadings(regulator, "M201")
+	if err != nil || len(readings) != 5 || readings[0].Source != statusShipped || readings[3].Source != odometerSourceService || readings[4].Reading != 8000 {
+		t.Fatalf("Unexpected odometer readings %+v (%v)", readings, err)
+	}
+}
+
+func TestActiveLienBlocksSaleAndTransfer(t *testing.T) {
+	s := new(CarChainCode)
+	stub := newTestStub()
+	manufacturer := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "manufacturer", "participantId": "MOrg01"})
+	dealer := asSubmitter(t, stub, "Org2MSP", map[string]string{"role": "dealer", "participantId": "D101"})
+	owner := asSubmitter(t, stub, "Org3MSP", map[string]string{"role": "consumer", "participantId": "CUST101"})
+	buyer := asSubmitter(t, stub, "Org3MSP", map[string]string{"role": "consumer", "participantId": "CUST102"})
+	lender := asSubmitter(t, stub, "Org5MSP", map[string]string{"role": "lender", "participantId": "BANK1"})
+	otherLender := asSubmitter(t, stub, "Org5MSP", map[string]string{"role": "lender", "participantId": "BANK2"})
+
+	if _, err := s.CreateCar(manufacturer, CarInput{ManufacturerId: "MOrg01", CarId: "M201", CarMake: "2022", CarModel: "MOrg01CM201", CarColor: "Red", ManufacturingDate: "2022-01-01T00:00:00Z"}); err != nil {
+		t.Fatalf("Failed to create car: %s", err)
+	}
+	if _, err := s.OfferLien(lender, "M201", 300000); err == nil {
+		t.Fatal("Expected a lien on a car held by no dealer or consumer to be refused")
+	}
+	if err := s.ShipToDealer(manufacturer, "M201", "D101", "CARRIER1", 0); err != nil {
+		t.Fatalf("Failed to ship car: %s", err)
+	}
+	if err := s.ReceiveDelivery(dealer, "M201", 0); err != nil {
+		t.Fatalf("Failed to receive car: %s", err)
+	}
+
+	stub.MockTransactionStart("lien1")
+	if _, err := s.OfferLien(dealer, "M201", 300000); err == nil {
+		t.Fatal("Expected OfferLien to require the lender role")
+	}
+	if lien, err := s.OfferLien(lender, "M201", 300000); err != nil || lien.DebtorType != roleDealer || lien.DebtorId != "D101" || lien.Status != lienOffered {
+		t.Fatalf("Expected a floor plan lien offered to the dealer, got %+v (%v)", lien, err)
+	}
+	if _, err := s.AcceptLien(dealer, "M201", "lien1"); err != nil {
+		t.Fatalf("Failed to accept lien: %s", err)
+	}
+	if err := s.SellToCustomer(dealer, "M201", "CUST101", 0); err == nil {
+		t.Fatal("Expected a car under lien not to be sold")
+	}
+	if _, err := s.ReleaseLien(otherLender, "M201"); err == nil {
+		t.Fatal("Expected another lender not to release the lien")
+	}
+	if _, err := s.ReleaseLien(lender, "M201"); err != nil {
+		t.Fatalf("Failed to release lien: %s", err)
+	}
+	if err := s.SellToCustomer(dealer, "M201", "CUST101", 0); err != nil {
+		t.Fatalf("Failed to sell car: %s", err)
+	}
+
+	stub.MockTransactionStart("lien2")
+	if _, err := s.OfferLien(lender, "M201", 500000); err != nil {
+		t.Fatalf("Failed to offer lien: %s", err)
+	}
+	if _, err := s.AcceptLien(owner, "M201", "lien2"); err != nil {
+		t.Fatalf("Failed to accept lien: %s", err)
+	}
+	if _, err := s.OfferLien(otherLender, "M201", 1000); err == nil {
+		t.Fatal("Expected a second active lien to be refused")
+	}
+	if car, _ := s.QueryCar(owner, "M201"); car.LienId != "lien2" || car.LienHolderId != "BANK1" {
+		t.Fatalf("Expected the car to show the active lien, got %+v", car)
+	}
+	if err := s.OfferTransfer(owner, "M201", "CUST102", roleConsumer); err == nil {
+		t.Fatal("Expected a car under lien not to be offered")
+	}
+	if _, err := s.ReleaseLien(lender, "M201"); err != nil {
+		t.Fatalf("Failed to release lien: %s", err)
+	}
+	if err := s.OfferTransfer(owner, "M201", "CUST102", roleConsumer); err != nil {
+		t.Fatalf("Failed to offer transfer: %s", err)
+	}
+	if err := s.AcceptTransfer(buyer, "M201", 0); err != nil {
+		t.Fatalf("Failed to accept transfer: %s", err)
+	}
+
+	liens, err := s.QueryLiens(owner, "M201")
+	if err != nil || len(liens) != 2 || liens[0].Status != lienReleased || liens[1].Status != lienReleased || liens[1].DebtorId != "CUST101" {
+		t.Fatalf("Unexpected liens %+v (%v)", liens, err)
+	}
+}
+
+func TestActiveLienBlocksReturnAndScrap(t *testing.T) {
+	s := new(CarChainCode)
+	stub := newTestStub()
+	manufacturer := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "manufacturer", "participantId": "MOrg01"})
+	dealer := asSubmitter(t, stub, "Org2MSP", map[string]string{"role": "dealer", "participantId": "D101"})
+	lender := asSubmitter(t, stub, "Org5MSP", map[string]string{"role": "lender", "participantId": "BANK1"})
+	recycler := asSubmitter(t, stub, "Org4MSP", map[string]string{"role": "recycler"})
+	certificateHash := strings.Repeat("ab", 32)
+
+	if _, err := s.CreateCar(manufacturer, CarInput{ManufacturerId: "MOrg01", CarId: "M201", CarMake: "2022", CarModel: "MOrg01CM201", CarColor: "Red", ManufacturingDate: "2022-01-01T00:00:00Z"}); err != nil {
+		t.Fatalf("Failed to create car: %s", err)
+	}
+	if err := s.ShipToDealer(manufacturer, "M201", "D101", "CARRIER1", 0); err != nil {
+		t.Fatalf("Failed to ship car: %s", err)
+	}
+	if err := s.ReceiveDelivery(dealer, "M201", 0); err != nil {
+		t.Fatalf("Failed to receive car: %s", err)
+	}
+	stub.MockTransactionStart("lien1")
+	if _, err := s.OfferLien(lender, "M201", 300000); err != nil {
+		t.Fatalf("Failed to offer lien: %s", err)
+	}
+	if _, err := s.AcceptLien(dealer, "M201", "lien1"); err != nil {
+		t.Fatalf("Failed to accept lien: %s", err)
+	}
+
+	if _, err := s.ReturnToManufacturer(dealer, "M201", "Unsold", 0); err == nil {
+		t.Fatal("Expected a car under lien not to be returned")
+	}
+	if err := s.ScrapCar(recycler, "M201", certificateHash, 0); err == nil {
+		t.Fatal("Expected a car under lien not to be scrapped")
+	}
+	if car, _ := s.QueryCar(dealer, "M201"); car.Status != statusReadyForSale || car.DealerId != "D101" {
+		t.Fatalf("Expected the car to stay with the dealer, got %+v", car)
+	}
+
+	if _, err := s.ReleaseLien(lender, "M201"); err != nil {
+		t.Fatalf("Failed to release lien: %s", err)
+	}
+	if _, err := s.ReturnToManufacturer(dealer, "M201", "Unsold", 0); err != nil {
+		t.Fatalf("Failed to return car: %s", err)
+	}
+	if err := s.ScrapCar(recycler, "M201", certificateHash, 0); err != nil {
+		t.Fatalf("Failed to scrap car: %s", err)
+	}
+}
+
+func TestLienNeedsDebtorConsentAndActiveLender(t *testing.T) {
+	s := new(CarChainCode)
+	stub := newTestStub()
+	manufacturer := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "manufacturer", "participantId": "MOrg01"})
+	admin := asSubmitter(t, stub, "Org1MSP", map[string]string{"role": "admin"})
+	dealer := asSubmitter(t, stub, "Org2MSP", map[string]string{"role": "dealer", "participantId": "D101"})
+	owner := asSubmitter(t, stub, "Org3MSP", map[string]string{"role": "consumer", "participantId": "CUST101"})
+	otherConsumer := asSubmitter(t, stub, "Org3MSP", map[string]string{"role": "consumer", "participantId": "CUST102"})
+	lender := asSubmitter(t, stub, "Org5MSP", map[string]string{"role": "lender", "participantId": "BANK1"})
+	otherLender := asSubmitter(t, stub, "Org5MSP", map[string]string{"role": "lender", "participantId": "BANK2"})
+	unknownLender := asSubmitter(t, stub, "Org5MSP", map[string]string{"role": "lender", "participantId": "BANK9"})
+
+	if _, err := s.CreateCar(manufacturer, CarInput{ManufacturerId: "MOrg01", CarId: "M201", CarMake: "2022", CarModel: "MOrg01CM201", CarColor: "Red", ManufacturingDate: "2022-01-01T00:00:00Z"}); err != nil {
+		t.Fatalf("Failed to create car: %s", err)
+	}
+	if err := s.ShipToDealer(manufacturer, "M201", "D101", "CARRIER1", 0); err != nil {
+		t.Fatalf("Failed to ship car: %s", err)
+	}
+	if err := s.ReceiveDelivery(dealer, "M201", 0); err != nil {
+		t.Fatalf("Failed to receive car: %s", err)
+	}
+
+	if _, err := s.OfferLien(unknownLender, "M201", 300000); err == nil {
+		t.Fatal("Expected an unregistered lender to be refused")
+	}
+	if err := s.DeactivateParticipant(admin, roleLender, "BANK2"); err != nil {
+		t.Fatalf("Failed to deactivate lender: %s", err)
+	}
+	if _, err := s.OfferLien(otherLender, "M201", 300000); err == nil {
+		t.Fatal("Expected a deactivated lender to be refused")
+	}
+
+	// a lien offered on the dealer's stock lapses once the car is sold
+	stub.MockTransactionStart("lien1")
+	if _, err := s.OfferLien(lender, "M201", 300000); err != nil {
+		t.Fatalf("Failed to offer lien: %s", err)
+	}
+	if car, _ := s.QueryCar(dealer, "M201"); car.LienId != "" {
+		t.Fatalf("Expected an offered lien not to bind the car, got %+v", car)
+	}
+	if err := s.SellToCustomer(dealer, "M201", "CUST101", 0); err != nil {
+		t.Fatalf("Failed to sell car: %s", err)
+	}
+	if _, err := s.AcceptLien(dealer, "M201", "lien1"); err == nil {
+		t.Fatal("Expected a lien on a car the dealer no longer holds not to be accepted")
+	}
+
+	stub.MockTransactionStart("lien2")
+	if _, err := s.OfferLien(lender, "M201", 300000); err != nil {
+		t.Fatalf("Failed to offer lien: %s", err)
+	}
+	if _, err := s.AcceptLien(otherConsumer, "M201", "lien2"); err == nil {
+		t.Fatal("Expected another consumer not to accept the lien")
+	}
+	if _, err := s.AcceptLien(lender, "M201", "lien2"); err == nil {
+		t.Fatal("Expected the lender not to accept its own lien")
+	}
+	if _, err := s.DeclineLien(otherConsumer, "M201", "lien2"); err == nil {
+		t.Fatal("Expected another consumer not to decline the lien")
+	}
+	if lien, err := s.DeclineLien(owner, "M201", "lien2"); err != nil || lien.Status != lienDeclined {
+		t.Fatalf("Expected the owner to decline the lien, got %+v (%v)", lien, err)
+	}
+	if _, err := s.AcceptLien(owner, "M201", "lien2"); err == nil {
+		t.Fatal("Expected a declined lien not to be accepted")
+	}
+	if car, _ := s.QueryCar(owner, "M201"); car.LienId != "" || car.Status != statusSold {
+		t.Fatalf("Expected the car to stay free of liens, got %+v", car)
+	}
+}
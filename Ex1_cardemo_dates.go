@@ -0,0 +1,93 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// dateLayout is the layout of every date stored on a Car
+const dateLayout = time.RFC3339
+
+// legacyDateLayout is the layout of the dates seeded by earlier versions of InitLedger
+const legacyDateLayout = "2006/01/02"
+
+// txDate returns the transaction's signed timestamp, which every endorser sees the same, formatted as dateLayout
+func txDate(ctx contractapi.TransactionContextInterface) (string, error) {
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return "", fmt.Errorf("Failed to read transaction timestamp. %s", err.Error())
+	}
+
+	return time.Unix(timestamp.Seconds, int64(timestamp.Nanos)).UTC().Format(dateLayout), nil
+}
+
+// validateDate fails unless the value is a dateLayout date
+func validateDate(name string, value string) error {
+	if _, err := time.Parse(dateLayout, value); err != nil {
+		return fmt.Errorf("%s must be an RFC3339 date, got %q", name, value)
+	}
+
+	return nil
+}
+
+// dateBefore reports whether date a is earlier than date b, ordering unparsable dates first
+func dateBefore(a string, b string) bool {
+	timeA, errA := time.Parse(dateLayout, a)
+	timeB, errB := time.Parse(dateLayout, b)
+	if errA != nil || errB != nil {
+		return errA != nil && errB == nil
+	}
+
+	return timeA.Before(timeB)
+}
+
+// migrateDate rewrites a legacyDateLayout date as dateLayout and reports whether it changed
+func migrateDate(value *string) bool {
+	date, err := time.Parse(legacyDateLayout, *value)
+	if err != nil {
+		return false
+	}
+
+	*value = date.UTC().Format(dateLayout)
+
+	return true
+}
+
+// MigrateDates rewrites legacy "2022/01/01" dates on every car as RFC3339 and returns how many cars changed
+func (s *CarChainCode) MigrateDates(ctx contractapi.TransactionContextInterface) (int, error) {
+	if err := s.requireRole(ctx, roleAdmin); err != nil {
+		return 0, err
+	}
+
+	cars, err := s.QueryAllCars(ctx, true)
+	if err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	for _, result := range cars {
+		car := result.Record
+		changed := false
+		for _, date := range []*string{&car.ManufacturingDate, &car.ShippingDate, &car.DeliveryDate, &car.SoldOnDate} {
+			if migrateDate(date) {
+				changed = true
+			}
+		}
+
+		if !changed {
+			continue
+		}
+
+		if err := s.putCar(ctx, result.Key, car); err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+
+	return migrated, nil
+}
@@ -0,0 +1,100 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Chaincode event names, one per life cycle status
+const (
+	eventCarCreated   = "CarCreated"
+	eventCarShipped   = "CarShipped"
+	eventCarDelivered = "CarDelivered"
+	eventCarSold      = "CarSold"
+	eventCarTradedIn  = "CarTradedIn"
+
+	eventCarDeliveryRejected = "CarDeliveryRejected"
+	eventCarDamaged          = "CarDamaged"
+	eventCarLost             = "CarLost"
+	eventCarReturned         = "CarReturned"
+
+	eventCarScrapped = "CarScrapped"
+)
+
+// eventCarTransferred is emitted when a car changes consumer without changing status
+const eventCarTransferred = "CarTransferred"
+
+// statusEvents names the event emitted when a car enters a status
+var statusEvents = map[string]string{
+	statusCreated:      eventCarCreated,
+	statusShipped:      eventCarShipped,
+	statusReadyForSale: eventCarDelivered,
+	statusSold:         eventCarSold,
+	statusTradedIn:     eventCarTradedIn,
+
+	statusDeliveryRejected: eventCarDeliveryRejected,
+	statusDamaged:          eventCarDamaged,
+	statusLost:             eventCarLost,
+	statusReturned:         eventCarReturned,
+
+	statusScrapped: eventCarScrapped,
+}
+
+// CarEvent is the JSON payload of every Car life cycle event
+type CarEvent struct {
+	EventName      string `json:"eventName"`
+	Key            string `json:"key"`
+	CarId          string `json:"carId"`
+	PreviousStatus string `json:"previousStatus"`
+	Status         string `json:"status"`
+	TxId           string `json:"txId"`
+	Car            *Car   `json:"car"`
+
+	// CarIds lists the cars of a batch event, which carries no single Car
+	CarIds []string `json:"carIds,omitempty" metadata:",optional"`
+}
+
+// emitCarEvent sets the chaincode event for a life cycle transition.
+// Writes that are not a legal transition, such as seeding or re-keying, emit nothing.
+func emitCarEvent(ctx contractapi.TransactionContextInterface, key string, previousStatus string, car *Car) error {
+	if previousStatus == car.Status {
+		return nil
+	}
+
+	if allowed, _ := canTransition(previousStatus, car.Status); !allowed {
+		return nil
+	}
+
+	eventName, ok := statusEvents[car.Status]
+	if !ok {
+		return nil
+	}
+
+	return setCarEvent(ctx, eventName, key, previousStatus, car)
+}
+
+// setCarEvent sets the named chaincode event with a CarEvent payload
+func setCarEvent(ctx contractapi.TransactionContextInterface, eventName string, key string, previousStatus string, car *Car) error {
+	event := CarEvent{
+		EventName:      eventName,
+		Key:            key,
+		CarId:          car.CarId,
+		PreviousStatus: previousStatus,
+		Status:         car.Status,
+		TxId:           ctx.GetStub().GetTxID(),
+		Car:            car,
+	}
+
+	eventAsBytes, _ := json.Marshal(event)
+
+	if err := ctx.GetStub().SetEvent(eventName, eventAsBytes); err != nil {
+		return fmt.Errorf("Failed to set %s event. %s", eventName, err.Error())
+	}
+
+	return nil
+}
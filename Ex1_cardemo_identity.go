@@ -0,0 +1,210 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Roles known to the Car life cycle
+const (
+	roleManufacturer  = "manufacturer"
+	roleDealer        = "dealer"
+	roleConsumer      = "consumer"
+	roleServiceCenter = "servicecenter"
+	roleCarrier       = "carrier"
+	roleRecycler      = "recycler"
+	roleRegulator     = "regulator"
+	roleLender        = "lender"
+	roleAdmin         = "admin"
+)
+
+// roleAttribute is the certificate attribute the default role rules look at
+const roleAttribute = "role"
+
+// participantAttribute is the certificate attribute naming the manufacturer, dealer or consumer id the submitter acts as
+const participantAttribute = "participantId"
+
+// roleRulesEnv names the environment variable holding a JSON array of RoleRule
+const roleRulesEnv = "CARDEMO_ROLE_RULES"
+
+// RoleRule maps a submitter identity onto an application role.
+// A rule matches when the submitter's MSP ID equals MSPID ("*" matches any MSP) and,
+// if Attribute is set, the X.509 certificate carries that attribute with the given Value.
+type RoleRule struct {
+	MSPID     string `json:"mspId"`
+	Attribute string `json:"attribute,omitempty"`
+	Value     string `json:"value,omitempty"`
+	Role      string `json:"role"`
+}
+
+// defaultRoleMSPs pins each role of the default rules to the MSPs allowed to issue it, so an organization
+// cannot grant itself a role another organization holds by issuing certificates from its own CA
+var defaultRoleMSPs = map[string][]string{
+	roleManufacturer:  {"Org1MSP"},
+	roleDealer:        {"Org2MSP"},
+	roleConsumer:      {"Org3MSP"},
+	roleServiceCenter: {"Org4MSP"},
+	roleCarrier:       {"Org4MSP"},
+	roleRecycler:      {"Org4MSP"},
+	roleRegulator:     {"Org4MSP"},
+	roleLender:        {"Org5MSP"},
+	roleAdmin:         {"Org1MSP", "Org2MSP"},
+}
+
+// defaultRoleRules grants a role to members of the role's pinned MSPs whose certificate carries role=<role>
+func defaultRoleRules() []RoleRule {
+	rules := []RoleRule{}
+	for _, role := range []string{roleManufacturer, roleDealer, roleConsumer, roleServiceCenter, roleCarrier, roleRecycler, roleRegulator, roleLender, roleAdmin} {
+		for _, mspID := range defaultRoleMSPs[role] {
+			rules = append(rules, RoleRule{MSPID: mspID, Attribute: roleAttribute, Value: role, Role: role})
+		}
+	}
+
+	return rules
+}
+
+// loadRoleRules reads the role mapping from the environment, falling back to the defaults
+func loadRoleRules() ([]RoleRule, error) {
+	raw := strings.TrimSpace(os.Getenv(roleRulesEnv))
+	if raw == "" {
+		return defaultRoleRules(), nil
+	}
+
+	rules := []RoleRule{}
+	if err := json.Unmarshal([]byte(raw), &rules); err != nil {
+		return nil, fmt.Errorf("Failed to parse %s. %s", roleRulesEnv, err.Error())
+	}
+
+	for i, rule := range rules {
+		if rule.MSPID == "" || rule.Role == "" {
+			return nil, fmt.Errorf("Role rule %d in %s must have mspId and role", i, roleRulesEnv)
+		}
+	}
+
+	return rules, nil
+}
+
+// rules returns the configured role rules, or the defaults when none were configured
+func (s *CarChainCode) rules() []RoleRule {
+	if s.roleRules == nil {
+		return defaultRoleRules()
+	}
+
+	return s.roleRules
+}
+
+// submitterRole resolves the role of the transaction submitter from its MSP ID and certificate
+func (s *CarChainCode) submitterRole(ctx contractapi.TransactionContextInterface) (string, error) {
+	identity := ctx.GetClientIdentity()
+	if identity == nil {
+		return "", fmt.Errorf("Failed to read submitter identity")
+	}
+
+	mspID, err := identity.GetMSPID()
+	if err != nil {
+		return "", fmt.Errorf("Failed to read submitter MSP ID. %s", err.Error())
+	}
+
+	for _, rule := range s.rules() {
+		if rule.MSPID != "*" && rule.MSPID != mspID {
+			continue
+		}
+
+		if rule.Attribute == "" {
+			return rule.Role, nil
+		}
+
+		value, found, err := identity.GetAttributeValue(rule.Attribute)
+		if err != nil {
+			return "", fmt.Errorf("Failed to read submitter attribute %s. %s", rule.Attribute, err.Error())
+		}
+
+		if found && value == rule.Value {
+			return rule.Role, nil
+		}
+	}
+
+	return "", fmt.Errorf("Submitter from %s has no role", mspID)
+}
+
+// requireRole fails unless the submitter holds one of the given roles
+func (s *CarChainCode) requireRole(ctx contractapi.TransactionContextInterface, roles ...string) error {
+	role, err := s.submitterRole(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to authorize submitter. %s", err.Error())
+	}
+
+	for _, allowed := range roles {
+		if role == allowed {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Failed to authorize submitter. Role %s is not one of %s", role, strings.Join(roles, ", "))
+}
+
+// submitterParticipant returns the participant id carried by the submitter's certificate
+func submitterParticipant(ctx contractapi.TransactionContextInterface) (string, error) {
+	participantId, found, err := ctx.GetClientIdentity().GetAttributeValue(participantAttribute)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read submitter attribute %s. %s", participantAttribute, err.Error())
+	}
+
+	if !found || participantId == "" {
+		return "", fmt.Errorf("Submitter certificate has no %s attribute", participantAttribute)
+	}
+
+	return participantId, nil
+}
+
+// registeredSubmitter returns the participant id carried by the submitter's certificate once the registry
+// confirms that participant belongs to the submitter's MSP, so one org cannot issue itself another org's participant ids
+func registeredSubmitter(ctx contractapi.TransactionContextInterface, participantType string) (string, error) {
+	participantId, err := submitterParticipant(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	participant := new(Participant)
+	found, err := getAsset(ctx, participantDocType, []string{participantType, participantId}, participant)
+	if err != nil {
+		return "", err
+	}
+
+	if !found {
+		return "", fmt.Errorf("Unknown %s %s", participantType, participantId)
+	}
+
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", fmt.Errorf("Failed to read submitter MSP ID. %s", err.Error())
+	}
+
+	if participant.MSPID != mspID {
+		return "", fmt.Errorf("The %s %s belongs to %s, not %s", participantType, participantId, participant.MSPID, mspID)
+	}
+
+	return participantId, nil
+}
+
+// requireSubmitterIs fails unless the submitter's certificate names the car's participant of the given type,
+// so one dealer or manufacturer cannot act on another's car
+func requireSubmitterIs(ctx contractapi.TransactionContextInterface, car *Car, participantType string, participantId string) error {
+	submitterId, err := registeredSubmitter(ctx, participantType)
+	if err != nil {
+		return err
+	}
+
+	if submitterId != participantId {
+		return fmt.Errorf("Car %s does not belong to %s %s", car.CarId, participantType, submitterId)
+	}
+
+	return nil
+}
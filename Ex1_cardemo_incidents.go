@@ -0,0 +1,245 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// deliveryIncidentType is the composite key object type of delivery incidents, keyed by carId then txId
+const deliveryIncidentType = "deliveryIncident"
+
+// InspectionReport is the dealer's inspection of a delivery it rejects
+type InspectionReport struct {
+	Inspector  string   `json:"inspector"`
+	Findings   string   `json:"findings"`
+	Defects    []string `json:"defects" metadata:",optional"`
+	ReportHash string   `json:"reportHash" metadata:",optional"`
+}
+
+// DeliveryIncident records a car leaving the happy path: a rejected delivery, damage or loss in transit, or a return
+type DeliveryIncident struct {
+	DocType     string            `json:"docType"`
+	CarId       string            `json:"carId"`
+	Status      string            `json:"status"`
+	DealerId    string            `json:"dealerId"`
+	ReportedBy  string            `json:"reportedBy"`
+	Description string            `json:"description"`
+	Inspection  *InspectionReport `json:"inspection,omitempty" metadata:",optional"`
+	ReportedOn  string            `json:"reportedOn"`
+	TxId        string            `json:"txId"`
+}
+
+// recordIncident moves the car to the incident's status and appends the incident to the car's incident records
+func (s *CarChainCode) recordIncident(ctx contractapi.TransactionContextInterface, car *Car, status string, description string, inspection *InspectionReport) (*DeliveryIncident, error) {
+	reportedBy, err := s.submitterRole(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := transition(car, status); err != nil {
+		return nil, err
+	}
+
+	reportedOn, err := txDate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	incident := DeliveryIncident{
+		DocType:     deliveryIncidentType,
+		CarId:       car.CarId,
+		Status:      status,
+		DealerId:    car.DealerId,
+		ReportedBy:  reportedBy,
+		Description: description,
+		Inspection:  inspection,
+		ReportedOn:  reportedOn,
+		TxId:        ctx.GetStub().GetTxID(),
+	}
+
+	if err := putAsset(ctx, deliveryIncidentType, []string{incident.CarId, incident.TxId}, incident); err != nil {
+		return nil, err
+	}
+
+	return &incident, nil
+}
+
+// RejectDelivery lets the dealer refuse a shipped car, recording its inspection report. The car goes back to the manufacturer
+// and the manufacturer pays the dealer back. A car under an active lien cannot be rejected.
+func (s *CarChainCode) RejectDelivery(ctx contractapi.TransactionContextInterface, carId string, report InspectionReport, odometer int) (*DeliveryIncident, error) {
+	if err := s.requireRole(ctx, roleDealer); err != nil {
+		return nil, err
+	}
+
+	if report.Inspector == "" || report.Findings == "" {
+		return nil, fmt.Errorf("Inspection report must name the inspector and describe the findings")
+	}
+
+	car, err := s.QueryCar(ctx, carId)
+	if err != nil {
+		return nil, err
+	}
+	if err := requireSubmitterIs(ctx, car, roleDealer, car.DealerId); err != nil {
+		return nil, err
+	}
+	if err := requireActiveParticipant(ctx, roleDealer, car.DealerId); err != nil {
+		return nil, err
+	}
+	if err := requireNoActiveLien(car); err != nil {
+		return nil, err
+	}
+
+	incident, err := s.recordIncident(ctx, car, statusDeliveryRejected, report.Findings, &report)
+	if err != nil {
+		return nil, err
+	}
+	car.DealerId = ""
+	if _, err := s.recordOdometer(ctx, car, odometer, car.Status); err != nil {
+		return nil, err
+	}
+
+	if err := refundShipment(ctx, carId); err != nil {
+		return nil, err
+	}
+
+	if err := s.putCar(ctx, carId, car); err != nil {
+		return nil, err
+	}
+
+	return incident, nil
+}
+
+// ReportTransitException lets the car's manufacturer or its receiving dealer mark a shipped car DAMAGED or LOST in transit.
+// The manufacturer pays the dealer back.
+func (s *CarChainCode) ReportTransitException(ctx contractapi.TransactionContextInterface, carId string, status string, description string) (*DeliveryIncident, error) {
+	if err := s.requireRole(ctx, roleManufacturer, roleDealer); err != nil {
+		return nil, err
+	}
+
+	if status != statusDamaged && status != statusLost {
+		return nil, fmt.Errorf("Transit exception status must be %s or %s, got %s", statusDamaged, statusLost, status)
+	}
+
+	if description == "" {
+		return nil, fmt.Errorf("Transit exception must be described")
+	}
+
+	car, err := s.QueryCar(ctx, carId)
+	if err != nil {
+		return nil, err
+	}
+
+	reporterType, err := s.submitterRole(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reporterId := car.DealerId
+	if reporterType == roleManufacturer {
+		reporterId = car.ManufacturerId
+	}
+	if err := requireSubmitterIs(ctx, car, reporterType, reporterId); err != nil {
+		return nil, err
+	}
+
+	if err := requireActiveParticipant(ctx, reporterType, reporterId); err != nil {
+		return nil, err
+	}
+
+	incident, err := s.recordIncident(ctx, car, status, description, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := refundShipment(ctx, carId); err != nil {
+		return nil, err
+	}
+
+	if err := s.putCar(ctx, carId, car); err != nil {
+		return nil, err
+	}
+
+	return incident, nil
+}
+
+// ReturnToManufacturer lets the dealer send unsold stock back to the manufacturer, moving the car to RETURNED.
+// The manufacturer pays the dealer back what it received for the car. A car under an active lien cannot be returned.
+func (s *CarChainCode) ReturnToManufacturer(ctx contractapi.TransactionContextInterface, carId string, reason string, odometer int) (*DeliveryIncident, error) {
+	if err := s.requireRole(ctx, roleDealer); err != nil {
+		return nil, err
+	}
+
+	if reason == "" {
+		return nil, fmt.Errorf("Return reason must be given")
+	}
+
+	car, err := s.QueryCar(ctx, carId)
+	if err != nil {
+		return nil, err
+	}
+	if err := requireSubmitterIs(ctx, car, roleDealer, car.DealerId); err != nil {
+		return nil, err
+	}
+	if err := requireActiveParticipant(ctx, roleDealer, car.DealerId); err != nil {
+		return nil, err
+	}
+	if err := requireNoActiveLien(car); err != nil {
+		return nil, err
+	}
+
+	incident, err := s.recordIncident(ctx, car, statusReturned, reason, nil)
+	if err != nil {
+		return nil, err
+	}
+	car.DealerId = ""
+	if _, err := s.recordOdometer(ctx, car, odometer, car.Status); err != nil {
+		return nil, err
+	}
+
+	if err := refundShipment(ctx, carId); err != nil {
+		return nil, err
+	}
+
+	if err := s.putCar(ctx, carId, car); err != nil {
+		return nil, err
+	}
+
+	return incident, nil
+}
+
+// QueryDeliveryIncidents returns the car's rejected deliveries, transit exceptions and returns, oldest first
+func (s *CarChainCode) QueryDeliveryIncidents(ctx contractapi.TransactionContextInterface, carId string) ([]DeliveryIncident, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(deliveryIncidentType, []string{carId})
+
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	incidents := []DeliveryIncident{}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		incident := DeliveryIncident{}
+		if err := json.Unmarshal(queryResponse.Value, &incident); err != nil {
+			return nil, fmt.Errorf("Failed to decode delivery incident %s. %s", queryResponse.Key, err.Error())
+		}
+		incidents = append(incidents, incident)
+	}
+
+	sort.SliceStable(incidents, func(i, j int) bool {
+		return dateBefore(incidents[i].ReportedOn, incidents[j].ReportedOn)
+	})
+
+	return incidents, nil
+}
@@ -0,0 +1,337 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// lienType is the composite key object type of liens, keyed by carId then lienId
+const lienType = "lien"
+
+// Lien states. A lender offers a lien, which binds the car only once its debtor accepts it.
+const (
+	lienOffered  = "OFFERED"
+	lienActive   = "ACTIVE"
+	lienDeclined = "DECLINED"
+	lienReleased = "RELEASED"
+)
+
+// Events emitted when a lien changes a car without changing its status
+const (
+	eventCarLienPlaced   = "CarLienPlaced"
+	eventCarLienReleased = "CarLienReleased"
+)
+
+// Lien is a lender's security interest in a car financed on credit, held against the car's owner at the time
+type Lien struct {
+	DocType    string `json:"docType"`
+	LienId     string `json:"lienId"`
+	CarId      string `json:"carId"`
+	LenderId   string `json:"lenderId"`
+	DebtorType string `json:"debtorType"`
+	DebtorId   string `json:"debtorId"`
+	Amount     int    `json:"amount"`
+	Status     string `json:"status"`
+	OfferedOn  string `json:"offeredOn"`
+	PlacedOn   string `json:"placedOn"`
+	ReleasedOn string `json:"releasedOn"`
+}
+
+// requireNoActiveLien fails if a lender still holds a lien on the car
+func requireNoActiveLien(car *Car) error {
+	if car.LienId != "" {
+		return fmt.Errorf("Car %s has active lien %s held by %s", car.CarId, car.LienId, car.LienHolderId)
+	}
+
+	return nil
+}
+
+// lienDebtor returns the participant a lien on the car is held against: its consumer, or the dealer for unsold stock
+func lienDebtor(car *Car) (string, string, error) {
+	if car.Status == statusScrapped || car.Status == statusLost {
+		return "", "", fmt.Errorf("Car %s is %s and cannot secure a lien", car.CarId, car.Status)
+	}
+
+	switch {
+	case car.ConsumerId != "":
+		return roleConsumer, car.ConsumerId, nil
+	case car.DealerId != "" && car.Status != statusShipped:
+		return roleDealer, car.DealerId, nil
+	}
+
+	return "", "", fmt.Errorf("Car %s is %s and held by no dealer or consumer to finance", car.CarId, car.Status)
+}
+
+// getLien reads the lien placed or offered on the car
+func getLien(ctx contractapi.TransactionContextInterface, carId string, lienId string) (*Lien, error) {
+	lien := new(Lien)
+	found, err := getAsset(ctx, lienType, []string{carId, lienId}, lien)
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, fmt.Errorf("Lien %s on car %s does not exist", lienId, carId)
+	}
+
+	return lien, nil
+}
+
+// lienDate returns the date the lien was offered, or placed for liens recorded before offers
+func lienDate(lien Lien) string {
+	if lien.OfferedOn != "" {
+		return lien.OfferedOn
+	}
+
+	return lien.PlacedOn
+}
+
+// OfferLien lets a registered lender offer a lien on a car it finances: a consumer's sold car, or a dealer's stock.
+// The lien binds the car only once its debtor accepts it with AcceptLien.
+func (s *CarChainCode) OfferLien(ctx contractapi.TransactionContextInterface, carId string, amount int) (*Lien, error) {
+	if err := s.requireRole(ctx, roleLender); err != nil {
+		return nil, err
+	}
+
+	lenderId, err := registeredSubmitter(ctx, roleLender)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := requireActiveParticipant(ctx, roleLender, lenderId); err != nil {
+		return nil, err
+	}
+
+	if amount <= 0 {
+		return nil, fmt.Errorf("Lien amount must be positive, got %d", amount)
+	}
+
+	car, err := s.QueryCar(ctx, carId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := requireNoActiveLien(car); err != nil {
+		return nil, err
+	}
+
+	lien := Lien{DocType: lienType, LienId: ctx.GetStub().GetTxID(), CarId: carId, LenderId: lenderId, Amount: amount, Status: lienOffered}
+	lien.DebtorType, lien.DebtorId, err = lienDebtor(car)
+	if err != nil {
+		return nil, err
+	}
+
+	lien.OfferedOn, err = txDate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := putAsset(ctx, lienType, []string{lien.CarId, lien.LienId}, lien); err != nil {
+		return nil, err
+	}
+
+	return &lien, nil
+}
+
+// AcceptLien lets the debtor of an offered lien agree to it, placing it on the car.
+// While the lien is active the car cannot be sold, transferred, returned or scrapped.
+func (s *CarChainCode) AcceptLien(ctx contractapi.TransactionContextInterface, carId string, lienId string) (*Lien, error) {
+	lien, err := getLien(ctx, carId, lienId)
+	if err != nil {
+		return nil, err
+	}
+
+	if lien.Status != lienOffered {
+		return nil, fmt.Errorf("Lien %s on car %s is %s, not %s", lienId, carId, lien.Status, lienOffered)
+	}
+
+	if err := s.requireRole(ctx, lien.DebtorType); err != nil {
+		return nil, err
+	}
+
+	debtorId, err := registeredSubmitter(ctx, lien.DebtorType)
+	if err != nil {
+		return nil, err
+	}
+
+	if debtorId != lien.DebtorId {
+		return nil, fmt.Errorf("Lien %s on car %s was offered to %s, not %s", lienId, carId, lien.DebtorId, debtorId)
+	}
+
+	if err := requireActiveParticipant(ctx, lien.DebtorType, debtorId); err != nil {
+		return nil, err
+	}
+
+	if err := requireActiveParticipant(ctx, roleLender, lien.LenderId); err != nil {
+		return nil, err
+	}
+
+	car, err := s.QueryCar(ctx, carId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := requireNoActiveLien(car); err != nil {
+		return nil, err
+	}
+
+	heldByType, heldById, err := lienDebtor(car)
+	if err != nil {
+		return nil, err
+	}
+
+	if heldByType != lien.DebtorType || heldById != lien.DebtorId {
+		return nil, fmt.Errorf("Car %s is no longer held by %s %s", carId, lien.DebtorType, lien.DebtorId)
+	}
+
+	lien.Status = lienActive
+	lien.PlacedOn, err = txDate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := putAsset(ctx, lienType, []string{lien.CarId, lien.LienId}, lien); err != nil {
+		return nil, err
+	}
+
+	car.LienId = lien.LienId
+	car.LienHolderId = lien.LenderId
+	if err := s.putCar(ctx, carId, car); err != nil {
+		return nil, err
+	}
+
+	if err := setCarEvent(ctx, eventCarLienPlaced, carId, car.Status, car); err != nil {
+		return nil, err
+	}
+
+	return lien, nil
+}
+
+// DeclineLien withdraws an offered lien. Either its debtor or the lender that offered it may decline.
+func (s *CarChainCode) DeclineLien(ctx contractapi.TransactionContextInterface, carId string, lienId string) (*Lien, error) {
+	lien, err := getLien(ctx, carId, lienId)
+	if err != nil {
+		return nil, err
+	}
+
+	if lien.Status != lienOffered {
+		return nil, fmt.Errorf("Lien %s on car %s is %s, not %s", lienId, carId, lien.Status, lienOffered)
+	}
+
+	if err := s.requireRole(ctx, roleLender, lien.DebtorType); err != nil {
+		return nil, err
+	}
+
+	role, err := s.submitterRole(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	participant, err := registeredSubmitter(ctx, role)
+	if err != nil {
+		return nil, err
+	}
+
+	isLender := role == roleLender && participant == lien.LenderId
+	isDebtor := role == lien.DebtorType && participant == lien.DebtorId
+	if !isLender && !isDebtor {
+		return nil, fmt.Errorf("Submitter %s is not a party to lien %s on car %s", participant, lienId, carId)
+	}
+
+	lien.Status = lienDeclined
+	if err := putAsset(ctx, lienType, []string{lien.CarId, lien.LienId}, lien); err != nil {
+		return nil, err
+	}
+
+	return lien, nil
+}
+
+// ReleaseLien lets the lender holding the car's active lien release it once the debt is paid
+func (s *CarChainCode) ReleaseLien(ctx contractapi.TransactionContextInterface, carId string) (*Lien, error) {
+	if err := s.requireRole(ctx, roleLender); err != nil {
+		return nil, err
+	}
+
+	lenderId, err := registeredSubmitter(ctx, roleLender)
+	if err != nil {
+		return nil, err
+	}
+
+	car, err := s.QueryCar(ctx, carId)
+	if err != nil {
+		return nil, err
+	}
+
+	if car.LienId == "" {
+		return nil, fmt.Errorf("Car %s has no active lien", carId)
+	}
+
+	if car.LienHolderId != lenderId {
+		return nil, fmt.Errorf("Lien %s on car %s is held by %s, not %s", car.LienId, carId, car.LienHolderId, lenderId)
+	}
+
+	lien, err := getLien(ctx, carId, car.LienId)
+	if err != nil {
+		return nil, err
+	}
+
+	lien.Status = lienReleased
+	lien.ReleasedOn, err = txDate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := putAsset(ctx, lienType, []string{lien.CarId, lien.LienId}, lien); err != nil {
+		return nil, err
+	}
+
+	car.LienId = ""
+	car.LienHolderId = ""
+	if err := s.putCar(ctx, carId, car); err != nil {
+		return nil, err
+	}
+
+	if err := setCarEvent(ctx, eventCarLienReleased, carId, car.Status, car); err != nil {
+		return nil, err
+	}
+
+	return lien, nil
+}
+
+// QueryLiens returns every lien offered or placed on the car, whatever its status, in the order they were offered
+func (s *CarChainCode) QueryLiens(ctx contractapi.TransactionContextInterface, carId string) ([]Lien, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(lienType, []string{carId})
+
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	liens := []Lien{}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		lien := Lien{}
+		if err := json.Unmarshal(queryResponse.Value, &lien); err != nil {
+			return nil, fmt.Errorf("Failed to decode lien %s. %s", queryResponse.Key, err.Error())
+		}
+		liens = append(liens, lien)
+	}
+
+	sort.SliceStable(liens, func(i, j int) bool {
+		return dateBefore(lienDate(liens[i]), lienDate(liens[j]))
+	})
+
+	return liens, nil
+}
@@ -0,0 +1,105 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+package main
+
+import (
+	"fmt"
+)
+
+// Car life cycle states
+const (
+	statusNone         = ""
+	statusCreated      = "CREATED"
+	statusShipped      = "SHIPPED"
+	statusReadyForSale = "READY_FOR_SALE"
+	statusSold         = "SOLD"
+	statusTradedIn     = "TRADED_IN"
+
+	statusDeliveryRejected = "DELIVERY_REJECTED"
+	statusDamaged          = "DAMAGED"
+	statusLost             = "LOST"
+	statusReturned         = "RETURNED"
+
+	statusScrapped = "SCRAPPED"
+)
+
+// transitions lists, for each state, the states a car may move to next.
+// statusNone is the state of a car that does not exist yet.
+// Rejected, damaged and returned cars go back to the manufacturer, which may ship them again; a lost car stays lost.
+// Any car that is not in transit may be scrapped, and a scrapped car never moves again.
+var transitions = map[string][]string{
+	statusNone:             {statusCreated},
+	statusCreated:          {statusShipped, statusScrapped},
+	statusShipped:          {statusReadyForSale, statusDeliveryRejected, statusDamaged, statusLost},
+	statusReadyForSale:     {statusSold, statusReturned, statusScrapped},
+	statusSold:             {statusTradedIn, statusScrapped},
+	statusTradedIn:         {statusSold, statusScrapped},
+	statusDeliveryRejected: {statusShipped, statusScrapped},
+	statusDamaged:          {statusShipped, statusScrapped},
+	statusReturned:         {statusShipped, statusScrapped},
+	statusLost:             {},
+	statusScrapped:         {},
+}
+
+// TransitionError reports a status change the life cycle does not allow
+type TransitionError struct {
+	CarId string
+	From  string
+	To    string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("Car %s cannot move from %s to %s", e.CarId, displayStatus(e.From), e.To)
+}
+
+// UnknownStatusError reports a car whose current status is not part of the life cycle
+type UnknownStatusError struct {
+	CarId  string
+	Status string
+}
+
+func (e *UnknownStatusError) Error() string {
+	return fmt.Sprintf("Car %s has unknown status %s", e.CarId, e.Status)
+}
+
+// displayStatus names statusNone in error messages
+func displayStatus(status string) string {
+	if status == statusNone {
+		return "<none>"
+	}
+
+	return status
+}
+
+// canTransition reports whether the life cycle allows moving from one status to another
+func canTransition(from string, to string) (bool, error) {
+	next, ok := transitions[from]
+	if !ok {
+		return false, fmt.Errorf("unknown status %s", from)
+	}
+
+	for _, status := range next {
+		if status == to {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// transition moves the car to the requested status, or fails if the life cycle forbids it
+func transition(car *Car, to string) error {
+	allowed, err := canTransition(car.Status, to)
+	if err != nil {
+		return &UnknownStatusError{CarId: car.CarId, Status: car.Status}
+	}
+
+	if !allowed {
+		return &TransitionError{CarId: car.CarId, From: car.Status, To: to}
+	}
+
+	car.Status = to
+
+	return nil
+}
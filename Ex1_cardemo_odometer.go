@@ -0,0 +1,169 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// odometerReadingType is the composite key object type of odometer readings, keyed by carId then txId
+const odometerReadingType = "odometerReading"
+
+// Sources of odometer readings that are not a life cycle status
+const (
+	odometerSourceService       = "SERVICE"
+	odometerSourceManual        = "MANUAL"
+	odometerSourceWarrantyClaim = "WARRANTY_CLAIM"
+)
+
+// eventOdometerRollback is emitted when a reading outside a life cycle transition is lower than the car's odometer
+const eventOdometerRollback = "CarOdometerRollback"
+
+// OdometerReading is one odometer value taken at a life cycle transition, a service visit, a warranty claim or with RecordOdometer
+type OdometerReading struct {
+	DocType    string `json:"docType"`
+	CarId      string `json:"carId"`
+	Reading    int    `json:"reading"`
+	Source     string `json:"source"`
+	RecordedBy string `json:"recordedBy"`
+	RecordedOn string `json:"recordedOn"`
+	Rollback   bool   `json:"rollback"`
+	TxId       string `json:"txId"`
+}
+
+// recordOdometer stores a reading of the car's odometer. The car keeps its highest reading. A life cycle transition,
+// whose source is the car's new status, refuses a lower reading; any other source stores it as a rollback and flags
+// the car as possible odometer fraud. The caller writes the car.
+func (s *CarChainCode) recordOdometer(ctx contractapi.TransactionContextInterface, car *Car, reading int, source string) (*OdometerReading, error) {
+	if reading < 0 {
+		return nil, fmt.Errorf("Odometer cannot be negative, got %d", reading)
+	}
+
+	if _, lifecycle := transitions[source]; lifecycle && reading < car.Odometer {
+		return nil, fmt.Errorf("Car %s reads %d on the odometer and cannot move to %s with the lower reading %d", car.CarId, car.Odometer, source, reading)
+	}
+
+	recordedBy, err := s.submitterRole(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	recordedOn, err := txDate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	record := OdometerReading{
+		DocType:    odometerReadingType,
+		CarId:      car.CarId,
+		Reading:    reading,
+		Source:     source,
+		RecordedBy: recordedBy,
+		RecordedOn: recordedOn,
+		Rollback:   reading < car.Odometer,
+		TxId:       ctx.GetStub().GetTxID(),
+	}
+
+	if record.Rollback {
+		car.OdometerRollback = true
+	} else {
+		car.Odometer = reading
+	}
+
+	if err := putAsset(ctx, odometerReadingType, []string{record.CarId, record.TxId}, record); err != nil {
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+// putCarWithReading writes a car whose status did not change after recordOdometer, emitting eventOdometerRollback for a rollback
+func (s *CarChainCode) putCarWithReading(ctx contractapi.TransactionContextInterface, car *Car, record *OdometerReading) error {
+	if err := s.putCar(ctx, car.CarId, car); err != nil {
+		return err
+	}
+
+	if !record.Rollback {
+		return nil
+	}
+
+	return setCarEvent(ctx, eventOdometerRollback, car.CarId, car.Status, car)
+}
+
+// RecordOdometer lets a dealer, service centre or regulator record a reading of the car's odometer outside the
+// life cycle transactions and service visits, such as at a roadworthiness inspection
+func (s *CarChainCode) RecordOdometer(ctx contractapi.TransactionContextInterface, carId string, reading int) (*OdometerReading, error) {
+	if err := s.requireRole(ctx, roleDealer, roleServiceCenter, roleRegulator); err != nil {
+		return nil, err
+	}
+
+	car, err := s.QueryCar(ctx, carId)
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := s.recordOdometer(ctx, car, reading, odometerSourceManual)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.putCarWithReading(ctx, car, record); err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// QueryOdometerReadings returns the car's odometer readings in the order they were recorded
+func (s *CarChainCode) QueryOdometerReadings(ctx contractapi.TransactionContextInterface, carId string) ([]OdometerReading, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(odometerReadingType, []string{carId})
+
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	readings := []OdometerReading{}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		reading := OdometerReading{}
+		if err := json.Unmarshal(queryResponse.Value, &reading); err != nil {
+			return nil, fmt.Errorf("Failed to decode odometer reading %s. %s", queryResponse.Key, err.Error())
+		}
+		readings = append(readings, reading)
+	}
+
+	sort.SliceStable(readings, func(i, j int) bool {
+		return dateBefore(readings[i].RecordedOn, readings[j].RecordedOn)
+	})
+
+	return readings, nil
+}
+
+// QueryOdometerFlaggedCars returns every car with a reading lower than an earlier one, scrapped cars included
+func (s *CarChainCode) QueryOdometerFlaggedCars(ctx contractapi.TransactionContextInterface) ([]QueryResult, error) {
+	cars, err := s.QueryAllCars(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	flagged := []QueryResult{}
+	for _, result := range cars {
+		if result.Record.OdometerRollback {
+			flagged = append(flagged, result)
+		}
+	}
+
+	return flagged, nil
+}
@@ -0,0 +1,270 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// transferOfferType is the composite key object type of pending transfer offers
+const transferOfferType = "transferOffer"
+
+// Ownership is one link in a car's chain of owners
+type Ownership struct {
+	OwnerId   string `json:"ownerId"`
+	OwnerType string `json:"ownerType"`
+	Since     string `json:"since"`
+	TxId      string `json:"txId"`
+}
+
+// TransferOffer is a sold car's owner offering it to a new consumer, or trading it back in to a dealer
+type TransferOffer struct {
+	CarId       string `json:"carId"`
+	FromOwnerId string `json:"fromOwnerId"`
+	ToOwnerId   string `json:"toOwnerId"`
+	ToOwnerType string `json:"toOwnerType"`
+	OfferedOn   string `json:"offeredOn"`
+}
+
+// ownersOf returns the car's ownership chain, starting it from the current consumer for cars sold before chains were recorded
+func ownersOf(car *Car) []Ownership {
+	if len(car.Owners) == 0 && car.ConsumerId != "" {
+		return []Ownership{{OwnerId: car.ConsumerId, OwnerType: roleConsumer, Since: car.SoldOnDate}}
+	}
+
+	return car.Owners
+}
+
+func transferOfferKey(ctx contractapi.TransactionContextInterface, carId string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(transferOfferType, []string{carId})
+	if err != nil {
+		return "", fmt.Errorf("Failed to create transfer offer key. %s", err.Error())
+	}
+
+	return key, nil
+}
+
+// QueryTransferOffer returns the pending transfer offer for the car
+func (s *CarChainCode) QueryTransferOffer(ctx contractapi.TransactionContextInterface, carId string) (*TransferOffer, error) {
+	key, err := transferOfferKey(ctx, carId)
+	if err != nil {
+		return nil, err
+	}
+
+	offerAsBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if offerAsBytes == nil {
+		return nil, fmt.Errorf("%s has no pending transfer offer", carId)
+	}
+
+	offer := new(TransferOffer)
+	_ = json.Unmarshal(offerAsBytes, offer)
+
+	return offer, nil
+}
+
+// OfferTransfer lets the consumer owning a sold car offer it to another consumer or trade it in to a dealer.
+// The transfer happens only once the new owner calls AcceptTransfer, and neither step is allowed while a lien is active.
+func (s *CarChainCode) OfferTransfer(ctx contractapi.TransactionContextInterface, carId string, newOwnerId string, newOwnerType string) error {
+	if err := s.requireRole(ctx, roleConsumer); err != nil {
+		return err
+	}
+
+	if newOwnerType != roleConsumer && newOwnerType != roleDealer {
+		return fmt.Errorf("New owner type must be %s or %s, got %s", roleConsumer, roleDealer, newOwnerType)
+	}
+
+	car, err := s.QueryCar(ctx, carId)
+	if err != nil {
+		return err
+	}
+
+	if car.Status != statusSold {
+		return fmt.Errorf("Car %s is %s and can only be transferred once SOLD", carId, car.Status)
+	}
+
+	if err := requireNoActiveLien(car); err != nil {
+		return err
+	}
+
+	owner, err := registeredSubmitter(ctx, roleConsumer)
+	if err != nil {
+		return err
+	}
+
+	if owner != car.ConsumerId {
+		return fmt.Errorf("Submitter %s does not own car %s", owner, carId)
+	}
+
+	if err := requireActiveParticipant(ctx, roleConsumer, owner); err != nil {
+		return err
+	}
+
+	if newOwnerId == "" || newOwnerId == owner {
+		return fmt.Errorf("New owner must differ from the current owner")
+	}
+
+	if err := requireActiveParticipant(ctx, newOwnerType, newOwnerId); err != nil {
+		return err
+	}
+
+	key, err := transferOfferKey(ctx, carId)
+	if err != nil {
+		return err
+	}
+
+	existing, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if existing != nil {
+		return fmt.Errorf("Car %s already has a pending transfer offer", carId)
+	}
+
+	offeredOn, err := txDate(ctx)
+	if err != nil {
+		return err
+	}
+
+	offer := TransferOffer{CarId: carId, FromOwnerId: owner, ToOwnerId: newOwnerId, ToOwnerType: newOwnerType, OfferedOn: offeredOn}
+	offerAsBytes, _ := json.Marshal(offer)
+
+	return ctx.GetStub().PutState(key, offerAsBytes)
+}
+
+// AcceptTransfer lets the offered new owner take the car, recording its odometer. A dealer accepting a trade-in moves the car to TRADED_IN.
+func (s *CarChainCode) AcceptTransfer(ctx contractapi.TransactionContextInterface, carId string, odometer int) error {
+	offer, err := s.QueryTransferOffer(ctx, carId)
+	if err != nil {
+		return err
+	}
+
+	if err := s.requireRole(ctx, offer.ToOwnerType); err != nil {
+		return err
+	}
+
+	newOwner, err := registeredSubmitter(ctx, offer.ToOwnerType)
+	if err != nil {
+		return err
+	}
+
+	if newOwner != offer.ToOwnerId {
+		return fmt.Errorf("Transfer of %s was offered to %s, not %s", carId, offer.ToOwnerId, newOwner)
+	}
+
+	if err := requireActiveParticipant(ctx, offer.ToOwnerType, newOwner); err != nil {
+		return err
+	}
+
+	car, err := s.QueryCar(ctx, carId)
+	if err != nil {
+		return err
+	}
+
+	if car.ConsumerId != offer.FromOwnerId {
+		return fmt.Errorf("Car %s is no longer owned by %s", carId, offer.FromOwnerId)
+	}
+
+	if err := requireNoActiveLien(car); err != nil {
+		return err
+	}
+
+	since, err := txDate(ctx)
+	if err != nil {
+		return err
+	}
+
+	previousStatus := car.Status
+	owners := ownersOf(car)
+
+	if offer.ToOwnerType == roleDealer {
+		if err := transition(car, statusTradedIn); err != nil {
+			return err
+		}
+		car.DealerId = newOwner
+		car.ConsumerId = ""
+	} else {
+		car.ConsumerId = newOwner
+	}
+	car.Owners = append(owners, Ownership{OwnerId: newOwner, OwnerType: offer.ToOwnerType, Since: since, TxId: ctx.GetStub().GetTxID()})
+	if _, err := s.recordOdometer(ctx, car, odometer, car.Status); err != nil {
+		return err
+	}
+
+	key, err := transferOfferKey(ctx, carId)
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.GetStub().DelState(key); err != nil {
+		return fmt.Errorf("Failed to delete transfer offer. %s", err.Error())
+	}
+
+	if err := s.putCar(ctx, carId, car); err != nil {
+		return err
+	}
+
+	if car.Status == previousStatus {
+		return setCarEvent(ctx, eventCarTransferred, carId, previousStatus, car)
+	}
+
+	return nil
+}
+
+// CancelTransfer withdraws a pending offer. Either the current owner or the offered new owner may cancel.
+func (s *CarChainCode) CancelTransfer(ctx contractapi.TransactionContextInterface, carId string) error {
+	offer, err := s.QueryTransferOffer(ctx, carId)
+	if err != nil {
+		return err
+	}
+
+	if err := s.requireRole(ctx, roleConsumer, roleDealer); err != nil {
+		return err
+	}
+
+	role, err := s.submitterRole(ctx)
+	if err != nil {
+		return err
+	}
+
+	participant, err := registeredSubmitter(ctx, role)
+	if err != nil {
+		return err
+	}
+
+	isOwner := role == roleConsumer && participant == offer.FromOwnerId
+	isNewOwner := role == offer.ToOwnerType && participant == offer.ToOwnerId
+	if !isOwner && !isNewOwner {
+		return fmt.Errorf("Submitter %s is not a party to the transfer of %s", participant, carId)
+	}
+
+	key, err := transferOfferKey(ctx, carId)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().DelState(key)
+}
+
+// QueryCarOwners returns the car's chain of owners, oldest first
+func (s *CarChainCode) QueryCarOwners(ctx contractapi.TransactionContextInterface, carId string) ([]Ownership, error) {
+	car, err := s.QueryCar(ctx, carId)
+	if err != nil {
+		return nil, err
+	}
+
+	owners := ownersOf(car)
+	if owners == nil {
+		owners = []Ownership{}
+	}
+
+	return owners, nil
+}
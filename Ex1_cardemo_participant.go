@@ -0,0 +1,218 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// participantDocType is the doc type and composite key object type of participants, keyed by participant type then id
+const participantDocType = "participant"
+
+// ParticipantInput is the input of RegisterParticipant and UpdateParticipant
+type ParticipantInput struct {
+	ParticipantType string `json:"participantType"`
+	ParticipantId   string `json:"participantId"`
+	Name            string `json:"name"`
+	Location        string `json:"location" metadata:",optional"`
+	MSPID           string `json:"mspId"`
+}
+
+// Participant is a registered manufacturer, dealer or consumer
+type Participant struct {
+	DocType         string `json:"docType"`
+	ParticipantType string `json:"participantType"`
+	ParticipantId   string `json:"participantId"`
+	Name            string `json:"name"`
+	Location        string `json:"location"`
+	MSPID           string `json:"mspId"`
+	Active          bool   `json:"active"`
+	RegisteredOn    string `json:"registeredOn"`
+	UpdatedOn       string `json:"updatedOn"`
+}
+
+// validateParticipantType fails unless the type is one the registry holds
+func validateParticipantType(participantType string) error {
+	switch participantType {
+	case roleManufacturer, roleDealer, roleConsumer, roleLender:
+		return nil
+	}
+
+	return fmt.Errorf("Participant type must be %s, %s, %s or %s, got %s", roleManufacturer, roleDealer, roleConsumer, roleLender, participantType)
+}
+
+// requireActiveParticipant fails unless the participant is registered and not deactivated
+func requireActiveParticipant(ctx contractapi.TransactionContextInterface, participantType string, participantId string) error {
+	participant := new(Participant)
+	found, err := getAsset(ctx, participantDocType, []string{participantType, participantId}, participant)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return fmt.Errorf("Unknown %s %s", participantType, participantId)
+	}
+
+	if !participant.Active {
+		return fmt.Errorf("The %s %s is deactivated", participantType, participantId)
+	}
+
+	return nil
+}
+
+// RegisterParticipant adds a manufacturer, dealer or consumer to the registry
+func (s *CarChainCode) RegisterParticipant(ctx contractapi.TransactionContextInterface, input ParticipantInput) (*Participant, error) {
+	if err := s.requireRole(ctx, roleAdmin); err != nil {
+		return nil, err
+	}
+
+	if err := validateParticipantType(input.ParticipantType); err != nil {
+		return nil, err
+	}
+
+	if input.ParticipantId == "" || input.Name == "" || input.MSPID == "" {
+		return nil, fmt.Errorf("Participant id, name and MSP ID must be set")
+	}
+
+	existing := new(Participant)
+	found, err := getAsset(ctx, participantDocType, []string{input.ParticipantType, input.ParticipantId}, existing)
+	if err != nil {
+		return nil, err
+	}
+
+	if found {
+		return nil, fmt.Errorf("The %s %s is already registered", input.ParticipantType, input.ParticipantId)
+	}
+
+	registeredOn, err := txDate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	participant := Participant{
+		DocType:         participantDocType,
+		ParticipantType: input.ParticipantType,
+		ParticipantId:   input.ParticipantId,
+		Name:            input.Name,
+		Location:        input.Location,
+		MSPID:           input.MSPID,
+		Active:          true,
+		RegisteredOn:    registeredOn,
+		UpdatedOn:       registeredOn,
+	}
+
+	if err := putAsset(ctx, participantDocType, []string{participant.ParticipantType, participant.ParticipantId}, participant); err != nil {
+		return nil, err
+	}
+
+	return &participant, nil
+}
+
+// UpdateParticipant changes a registered participant's name, location and MSP ID
+func (s *CarChainCode) UpdateParticipant(ctx contractapi.TransactionContextInterface, input ParticipantInput) (*Participant, error) {
+	if err := s.requireRole(ctx, roleAdmin); err != nil {
+		return nil, err
+	}
+
+	participant, err := s.QueryParticipant(ctx, input.ParticipantType, input.ParticipantId)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.Name == "" || input.MSPID == "" {
+		return nil, fmt.Errorf("Participant name and MSP ID must be set")
+	}
+
+	participant.Name = input.Name
+	participant.Location = input.Location
+	participant.MSPID = input.MSPID
+	participant.UpdatedOn, err = txDate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := putAsset(ctx, participantDocType, []string{participant.ParticipantType, participant.ParticipantId}, participant); err != nil {
+		return nil, err
+	}
+
+	return participant, nil
+}
+
+// DeactivateParticipant stops the participant from taking part in any further life cycle transaction
+func (s *CarChainCode) DeactivateParticipant(ctx contractapi.TransactionContextInterface, participantType string, participantId string) error {
+	if err := s.requireRole(ctx, roleAdmin); err != nil {
+		return err
+	}
+
+	participant, err := s.QueryParticipant(ctx, participantType, participantId)
+	if err != nil {
+		return err
+	}
+
+	if !participant.Active {
+		return fmt.Errorf("The %s %s is already deactivated", participantType, participantId)
+	}
+
+	participant.Active = false
+	participant.UpdatedOn, err = txDate(ctx)
+	if err != nil {
+		return err
+	}
+
+	return putAsset(ctx, participantDocType, []string{participantType, participantId}, participant)
+}
+
+// QueryParticipant returns the registered participant
+func (s *CarChainCode) QueryParticipant(ctx contractapi.TransactionContextInterface, participantType string, participantId string) (*Participant, error) {
+	if err := validateParticipantType(participantType); err != nil {
+		return nil, err
+	}
+
+	participant := new(Participant)
+	found, err := getAsset(ctx, participantDocType, []string{participantType, participantId}, participant)
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, fmt.Errorf("Unknown %s %s", participantType, participantId)
+	}
+
+	return participant, nil
+}
+
+// QueryParticipants returns every registered participant of the given type
+func (s *CarChainCode) QueryParticipants(ctx contractapi.TransactionContextInterface, participantType string) ([]Participant, error) {
+	if err := validateParticipantType(participantType); err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(participantDocType, []string{participantType})
+
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	participants := []Participant{}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		participant := Participant{}
+		if err := json.Unmarshal(queryResponse.Value, &participant); err != nil {
+			return nil, fmt.Errorf("Failed to decode participant %s. %s", queryResponse.Key, err.Error())
+		}
+		participants = append(participants, participant)
+	}
+
+	return participants, nil
+}
@@ -0,0 +1,212 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Private data collections holding commercial prices, see collections_config.json
+const (
+	manufacturerDealerCollection = "manufacturerDealerPrices"
+	dealerConsumerCollection     = "dealerConsumerPrices"
+)
+
+// dealerConsumerSaleType is the composite key object type of customer prices, keyed by carId then the sale's txId,
+// so a car resold after a trade-in keeps the price of every sale
+const dealerConsumerSaleType = "sale"
+
+// transientPricesKey is the transient map entry carrying a transaction's CarPrices as JSON
+const transientPricesKey = "prices"
+
+// CarPrices is the transient input of the transactions that set prices.
+// Salt is mixed into the private record so the hash on the public ledger cannot be brute forced.
+type CarPrices struct {
+	ManufacturerPrice int    `json:"manufacturerPrice"`
+	ShippingPrice     int    `json:"shippingPrice"`
+	CustomerPrice     int    `json:"customerPrice"`
+	Salt              string `json:"salt"`
+}
+
+// ManufacturerDealerPrice is the private record shared by the manufacturer and the dealer the car was shipped to
+type ManufacturerDealerPrice struct {
+	CarId             string `json:"carId"`
+	DealerId          string `json:"dealerId,omitempty"`
+	ManufacturerPrice int    `json:"manufacturerPrice"`
+	ShippingPrice     int    `json:"shippingPrice"`
+	Salt              string `json:"salt"`
+}
+
+// DealerConsumerPrice is the private record of one sale, shared by the dealer and the consumer.
+// Resale marks a car the dealer took in by trade-in rather than buying it from the manufacturer.
+type DealerConsumerPrice struct {
+	CarId         string `json:"carId"`
+	DealerId      string `json:"dealerId"`
+	ConsumerId    string `json:"consumerId"`
+	SoldOnDate    string `json:"soldOnDate"`
+	TxId          string `json:"txId"`
+	Resale        bool   `json:"resale"`
+	CustomerPrice int    `json:"customerPrice"`
+	Salt          string `json:"salt"`
+}
+
+// transientPrices reads the prices passed in the transient map
+func transientPrices(ctx contractapi.TransactionContextInterface) (*CarPrices, error) {
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read transient map. %s", err.Error())
+	}
+
+	pricesAsBytes, ok := transientMap[transientPricesKey]
+	if !ok {
+		return nil, fmt.Errorf("Prices must be passed in the transient map under %q", transientPricesKey)
+	}
+
+	prices := new(CarPrices)
+	if err := json.Unmarshal(pricesAsBytes, prices); err != nil {
+		return nil, fmt.Errorf("Failed to decode transient prices. %s", err.Error())
+	}
+
+	if prices.Salt == "" {
+		return nil, fmt.Errorf("Transient prices must carry a salt")
+	}
+
+	if prices.ManufacturerPrice < 0 || prices.ShippingPrice < 0 || prices.CustomerPrice < 0 {
+		return nil, fmt.Errorf("Prices cannot be negative")
+	}
+
+	return prices, nil
+}
+
+// requireCollectionMember fails unless the submitter belongs to the organization of the peer answering the query,
+// so a peer only hands its collection's prices to its own members
+func requireCollectionMember(ctx contractapi.TransactionContextInterface) error {
+	clientMSPID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("Failed to read submitter MSP ID. %s", err.Error())
+	}
+
+	peerMSPID, err := shim.GetMSPID()
+	if err != nil {
+		return fmt.Errorf("Failed to read peer MSP ID. %s", err.Error())
+	}
+
+	if clientMSPID != peerMSPID {
+		return fmt.Errorf("Submitter from %s is not authorized to read private data from %s peers", clientMSPID, peerMSPID)
+	}
+
+	return nil
+}
+
+// putPrivate writes a private record to the collection under the car id
+func putPrivate(ctx contractapi.TransactionContextInterface, collection string, carId string, record interface{}) error {
+	recordAsBytes, _ := json.Marshal(record)
+
+	if err := ctx.GetStub().PutPrivateData(collection, carId, recordAsBytes); err != nil {
+		return fmt.Errorf("Failed to put prices to %s. %s", collection, err.Error())
+	}
+
+	return nil
+}
+
+// getPrivate reads the private record stored in the collection under the car id
+func getPrivate(ctx contractapi.TransactionContextInterface, collection string, carId string, record interface{}) error {
+	recordAsBytes, err := ctx.GetStub().GetPrivateData(collection, carId)
+	if err != nil {
+		return fmt.Errorf("Failed to read prices from %s. %s", collection, err.Error())
+	}
+
+	if recordAsBytes == nil {
+		return fmt.Errorf("No prices for %s in %s", carId, collection)
+	}
+
+	return json.Unmarshal(recordAsBytes, record)
+}
+
+// carSales returns the price of every sale of the car, oldest first. Each sale adds its consumer to the car's owners
+// with the sale's txId, which keys its price. A price stored under the bare carId by earlier versions is returned as
+// the car's only sale.
+func carSales(ctx contractapi.TransactionContextInterface, car *Car) ([]DealerConsumerPrice, error) {
+	sales := []DealerConsumerPrice{}
+	for _, owner := range car.Owners {
+		if owner.OwnerType != roleConsumer || owner.TxId == "" {
+			continue
+		}
+
+		sale := DealerConsumerPrice{}
+		found, err := getPrivateAsset(ctx, dealerConsumerCollection, dealerConsumerSaleType, []string{car.CarId, owner.TxId}, &sale)
+		if err != nil {
+			return nil, err
+		}
+
+		if found {
+			sales = append(sales, sale)
+		}
+	}
+
+	if len(sales) > 0 || car.SoldOnDate == "" {
+		return sales, nil
+	}
+
+	legacy := DealerConsumerPrice{}
+	found, err := findPrivate(ctx, dealerConsumerCollection, car.CarId, &legacy)
+	if err != nil || !found {
+		return sales, err
+	}
+
+	legacy.DealerId = car.DealerId
+	legacy.ConsumerId = car.ConsumerId
+	legacy.SoldOnDate = car.SoldOnDate
+
+	return append(sales, legacy), nil
+}
+
+// QueryManufacturerDealerPrice returns the manufacturer and shipping price of the car to members of the manufacturer-dealer collection
+func (s *CarChainCode) QueryManufacturerDealerPrice(ctx contractapi.TransactionContextInterface, carId string) (*ManufacturerDealerPrice, error) {
+	if err := s.requireRole(ctx, roleManufacturer, roleDealer); err != nil {
+		return nil, err
+	}
+
+	if err := requireCollectionMember(ctx); err != nil {
+		return nil, err
+	}
+
+	price := new(ManufacturerDealerPrice)
+	if err := getPrivate(ctx, manufacturerDealerCollection, carId, price); err != nil {
+		return nil, err
+	}
+
+	return price, nil
+}
+
+// QueryDealerConsumerPrice returns the customer price of the car's latest sale to members of the dealer-consumer collection
+func (s *CarChainCode) QueryDealerConsumerPrice(ctx contractapi.TransactionContextInterface, carId string) (*DealerConsumerPrice, error) {
+	if err := s.requireRole(ctx, roleDealer, roleConsumer); err != nil {
+		return nil, err
+	}
+
+	if err := requireCollectionMember(ctx); err != nil {
+		return nil, err
+	}
+
+	car, err := s.QueryCar(ctx, carId)
+	if err != nil {
+		return nil, err
+	}
+
+	sales, err := carSales(ctx, car)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sales) == 0 {
+		return nil, fmt.Errorf("No prices for %s in %s", carId, dealerConsumerCollection)
+	}
+
+	return &sales[len(sales)-1], nil
+}
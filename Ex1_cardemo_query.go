@@ -0,0 +1,186 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// carDocType tags Car documents so rich queries never match other assets
+const carDocType = "car"
+
+// carKeyType is the composite key object type cars are stored under, keyed by car id
+const carKeyType = "CAR"
+
+// CarFilter selects cars by field value. Empty fields do not constrain the result.
+type CarFilter struct {
+	Status         string `json:"status" metadata:",optional"`
+	DealerId       string `json:"dealerId" metadata:",optional"`
+	ManufacturerId string `json:"manufacturerId" metadata:",optional"`
+	ConsumerId     string `json:"consumerId" metadata:",optional"`
+	CarModel       string `json:"carModel" metadata:",optional"`
+}
+
+// carIndex is a composite key index over one Car field, usable on LevelDB
+type carIndex struct {
+	objectType string
+	value      func(car *Car) string
+}
+
+// carIndexes are listed most selective first; QueryCarsByIndex scans the first one the filter sets
+var carIndexes = []carIndex{
+	{"car~consumerId", func(car *Car) string { return car.ConsumerId }},
+	{"car~dealerId", func(car *Car) string { return car.DealerId }},
+	{"car~carModel", func(car *Car) string { return car.CarModel }},
+	{"car~manufacturerId", func(car *Car) string { return car.ManufacturerId }},
+	{"car~status", func(car *Car) string { return car.Status }},
+}
+
+// filterValue returns the value the filter requires for the indexed field
+func (f *CarFilter) filterValue(index carIndex) string {
+	return index.value(&Car{Status: f.Status, DealerId: f.DealerId, ManufacturerId: f.ManufacturerId, ConsumerId: f.ConsumerId, CarModel: f.CarModel})
+}
+
+// matches reports whether the car satisfies every field the filter sets
+func (f *CarFilter) matches(car *Car) bool {
+	for _, index := range carIndexes {
+		want := f.filterValue(index)
+		if want != "" && want != index.value(car) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// selector builds the CouchDB query for the filter
+func (f *CarFilter) selector() (string, error) {
+	selector := map[string]interface{}{"docType": carDocType}
+	fields := map[string]string{
+		"status":         f.Status,
+		"dealerId":       f.DealerId,
+		"manufacturerId": f.ManufacturerId,
+		"consumerId":     f.ConsumerId,
+		"carModel":       f.CarModel,
+	}
+	for field, value := range fields {
+		if value != "" {
+			selector[field] = value
+		}
+	}
+
+	query, err := json.Marshal(map[string]interface{}{"selector": selector})
+	if err != nil {
+		return "", err
+	}
+
+	return string(query), nil
+}
+
+// updateCarIndexes moves the car's composite key index entries from the previous version to the next
+func updateCarIndexes(ctx contractapi.TransactionContextInterface, carId string, previous *Car, next *Car) error {
+	stub := ctx.GetStub()
+
+	for _, index := range carIndexes {
+		nextValue := index.value(next)
+
+		if previous != nil {
+			previousValue := index.value(previous)
+			if previousValue == nextValue {
+				continue
+			}
+
+			if previousValue != "" {
+				indexKey, err := stub.CreateCompositeKey(index.objectType, []string{previousValue, carId})
+				if err != nil {
+					return fmt.Errorf("Failed to create %s index key. %s", index.objectType, err.Error())
+				}
+				if err := stub.DelState(indexKey); err != nil {
+					return fmt.Errorf("Failed to delete %s index entry. %s", index.objectType, err.Error())
+				}
+			}
+		}
+
+		if nextValue != "" {
+			indexKey, err := stub.CreateCompositeKey(index.objectType, []string{nextValue, carId})
+			if err != nil {
+				return fmt.Errorf("Failed to create %s index key. %s", index.objectType, err.Error())
+			}
+			if err := stub.PutState(indexKey, []byte{0x00}); err != nil {
+				return fmt.Errorf("Failed to put %s index entry. %s", index.objectType, err.Error())
+			}
+		}
+	}
+
+	return nil
+}
+
+// QueryCarsBySelector returns the cars matching the filter using a CouchDB rich query
+func (s *CarChainCode) QueryCarsBySelector(ctx contractapi.TransactionContextInterface, filter CarFilter) ([]QueryResult, error) {
+	query, err := filter.selector()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to build query. %s", err.Error())
+	}
+
+	resultsIterator, err := ctx.GetStub().GetQueryResult(query)
+
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	return collectQueryResults(ctx, resultsIterator)
+}
+
+// QueryCarsByIndex returns the cars matching the filter using composite key indexes, so it also works on LevelDB
+func (s *CarChainCode) QueryCarsByIndex(ctx contractapi.TransactionContextInterface, filter CarFilter) ([]QueryResult, error) {
+	var scan *carIndex
+	for i := range carIndexes {
+		if filter.filterValue(carIndexes[i]) != "" {
+			scan = &carIndexes[i]
+			break
+		}
+	}
+
+	if scan == nil {
+		return nil, fmt.Errorf("Filter must set at least one field")
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(scan.objectType, []string{filter.filterValue(*scan)})
+
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	results := []QueryResult{}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		carId := attributes[1]
+		car, err := s.QueryCar(ctx, carId)
+		if err != nil {
+			return nil, err
+		}
+
+		if filter.matches(car) {
+			results = append(results, QueryResult{Key: carId, Record: car})
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,436 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Composite key object types of recall assets
+const (
+	recallType       = "recall"
+	recallNoticeType = "recallNotice"
+)
+
+// Recall states
+const (
+	recallOpen   = "OPEN"
+	recallClosed = "CLOSED"
+)
+
+// RecallCriteria selects the cars a recall affects. Set fields must all match; CarIds adds cars by id.
+type RecallCriteria struct {
+	CarModel         string   `json:"carModel" metadata:",optional"`
+	CarColor         string   `json:"carColor" metadata:",optional"`
+	ManufacturedFrom string   `json:"manufacturedFrom" metadata:",optional"`
+	ManufacturedTo   string   `json:"manufacturedTo" metadata:",optional"`
+	CarIds           []string `json:"carIds" metadata:",optional"`
+}
+
+// RecallInput is the input of CreateRecall
+type RecallInput struct {
+	RecallId    string         `json:"recallId"`
+	Description string         `json:"description"`
+	Criteria    RecallCriteria `json:"criteria"`
+}
+
+// Recall is a manufacturer's recall campaign
+type Recall struct {
+	DocType        string         `json:"docType"`
+	RecallId       string         `json:"recallId"`
+	ManufacturerId string         `json:"manufacturerId"`
+	Description    string         `json:"description"`
+	Criteria       RecallCriteria `json:"criteria"`
+	Status         string         `json:"status"`
+	IssuedOn       string         `json:"issuedOn"`
+	AffectedCarIds []string       `json:"affectedCarIds"`
+	RemediedCount  int            `json:"remediedCount"`
+}
+
+// RecallNotice tracks one car affected by a recall from acknowledgement to remedy
+type RecallNotice struct {
+	DocType        string `json:"docType"`
+	RecallId       string `json:"recallId"`
+	CarId          string `json:"carId"`
+	AcknowledgedBy string `json:"acknowledgedBy"`
+	AcknowledgedOn string `json:"acknowledgedOn"`
+	Remedy         string `json:"remedy"`
+	RemediedBy     string `json:"remediedBy"`
+	RemediedOn     string `json:"remediedOn"`
+}
+
+// matches reports whether the car falls under the criteria
+func (c *RecallCriteria) matches(car *Car) bool {
+	for _, carId := range c.CarIds {
+		if carId == car.CarId {
+			return true
+		}
+	}
+
+	if c.CarModel == "" && c.CarColor == "" && c.ManufacturedFrom == "" && c.ManufacturedTo == "" {
+		return false
+	}
+
+	if c.CarModel != "" && c.CarModel != car.CarModel {
+		return false
+	}
+
+	if c.CarColor != "" && c.CarColor != car.CarColor {
+		return false
+	}
+
+	if c.ManufacturedFrom != "" || c.ManufacturedTo != "" {
+		manufactured, err := time.Parse(dateLayout, car.ManufacturingDate)
+		if err != nil {
+			return false
+		}
+
+		if from, err := time.Parse(dateLayout, c.ManufacturedFrom); err == nil && manufactured.Before(from) {
+			return false
+		}
+
+		if to, err := time.Parse(dateLayout, c.ManufacturedTo); err == nil && manufactured.After(to) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// validate fails unless the criteria select something and their dates are well formed
+func (c *RecallCriteria) validate() error {
+	if c.CarModel == "" && c.CarColor == "" && c.ManufacturedFrom == "" && c.ManufacturedTo == "" && len(c.CarIds) == 0 {
+		return fmt.Errorf("Recall criteria must set at least one field")
+	}
+
+	if c.ManufacturedFrom != "" {
+		if err := validateDate("manufacturedFrom", c.ManufacturedFrom); err != nil {
+			return err
+		}
+	}
+
+	if c.ManufacturedTo != "" {
+		if err := validateDate("manufacturedTo", c.ManufacturedTo); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CreateRecall issues a recall against the submitting manufacturer's cars that match the criteria and flags each of them.
+// Scrapped cars are never recalled.
+func (s *CarChainCode) CreateRecall(ctx contractapi.TransactionContextInterface, input RecallInput) (*Recall, error) {
+	if err := s.requireRole(ctx, roleManufacturer); err != nil {
+		return nil, err
+	}
+
+	manufacturerId, err := registeredSubmitter(ctx, roleManufacturer)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.RecallId == "" {
+		return nil, fmt.Errorf("Recall id must be set")
+	}
+
+	if err := input.Criteria.validate(); err != nil {
+		return nil, err
+	}
+
+	existing := new(Recall)
+	found, err := getAsset(ctx, recallType, []string{input.RecallId}, existing)
+	if err != nil {
+		return nil, err
+	}
+
+	if found {
+		return nil, fmt.Errorf("Recall %s already exists", input.RecallId)
+	}
+
+	issuedOn, err := txDate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cars, err := s.QueryCarsByIndex(ctx, CarFilter{ManufacturerId: manufacturerId})
+	if err != nil {
+		return nil, err
+	}
+
+	recall := Recall{
+		DocType:        recallType,
+		RecallId:       input.RecallId,
+		ManufacturerId: manufacturerId,
+		Description:    input.Description,
+		Criteria:       input.Criteria,
+		Status:         recallOpen,
+		IssuedOn:       issuedOn,
+		AffectedCarIds: []string{},
+	}
+
+	for _, result := range cars {
+		car := result.Record
+		if car.Status == statusScrapped || !input.Criteria.matches(car) {
+			continue
+		}
+
+		car.OpenRecalls = append(car.OpenRecalls, recall.RecallId)
+		if err := s.putCar(ctx, result.Key, car); err != nil {
+			return nil, err
+		}
+
+		notice := RecallNotice{DocType: recallNoticeType, RecallId: recall.RecallId, CarId: car.CarId}
+		if err := putAsset(ctx, recallNoticeType, []string{recall.RecallId, car.CarId}, notice); err != nil {
+			return nil, err
+		}
+
+		recall.AffectedCarIds = append(recall.AffectedCarIds, car.CarId)
+	}
+
+	if len(recall.AffectedCarIds) == 0 {
+		return nil, fmt.Errorf("Recall %s matches no cars of %s", recall.RecallId, manufacturerId)
+	}
+
+	if err := putAsset(ctx, recallType, []string{recall.RecallId}, recall); err != nil {
+		return nil, err
+	}
+
+	return &recall, nil
+}
+
+// QueryRecall returns the recall with the given id
+func (s *CarChainCode) QueryRecall(ctx contractapi.TransactionContextInterface, recallId string) (*Recall, error) {
+	recall := new(Recall)
+	found, err := getAsset(ctx, recallType, []string{recallId}, recall)
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, fmt.Errorf("Recall %s does not exist", recallId)
+	}
+
+	return recall, nil
+}
+
+// recallNoticeForDealer loads the car's notice for the recall and checks the submitting dealer holds the car
+func (s *CarChainCode) recallNoticeForDealer(ctx contractapi.TransactionContextInterface, recallId string, carId string) (*RecallNotice, string, error) {
+	if err := s.requireRole(ctx, roleDealer); err != nil {
+		return nil, "", err
+	}
+
+	dealerId, err := registeredSubmitter(ctx, roleDealer)
+	if err != nil {
+		return nil, "", err
+	}
+
+	car, err := s.QueryCar(ctx, carId)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if car.DealerId != dealerId {
+		return nil, "", fmt.Errorf("Car %s is not held by dealer %s", carId, dealerId)
+	}
+
+	notice := new(RecallNotice)
+	found, err := getAsset(ctx, recallNoticeType, []string{recallId, carId}, notice)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !found {
+		return nil, "", fmt.Errorf("Car %s is not affected by recall %s", carId, recallId)
+	}
+
+	return notice, dealerId, nil
+}
+
+// AcknowledgeRecall records that the dealer holding the car has been notified of the recall
+func (s *CarChainCode) AcknowledgeRecall(ctx contractapi.TransactionContextInterface, recallId string, carId string) error {
+	notice, dealerId, err := s.recallNoticeForDealer(ctx, recallId, carId)
+	if err != nil {
+		return err
+	}
+
+	if notice.AcknowledgedOn != "" {
+		return fmt.Errorf("Recall %s on car %s is already acknowledged", recallId, carId)
+	}
+
+	notice.AcknowledgedBy = dealerId
+	notice.AcknowledgedOn, err = txDate(ctx)
+	if err != nil {
+		return err
+	}
+
+	return putAsset(ctx, recallNoticeType, []string{recallId, carId}, notice)
+}
+
+// RecordRecallRemedy records the remedy applied to an acknowledged recall, clearing the car's flag and closing the recall
+// once every car is remedied or can no longer be remedied, see recallSettled
+func (s *CarChainCode) RecordRecallRemedy(ctx contractapi.TransactionContextInterface, recallId string, carId string, remedy string) error {
+	notice, dealerId, err := s.recallNoticeForDealer(ctx, recallId, carId)
+	if err != nil {
+		return err
+	}
+
+	if notice.AcknowledgedOn == "" {
+		return fmt.Errorf("Recall %s on car %s must be acknowledged before its remedy is recorded", recallId, carId)
+	}
+
+	if notice.RemediedOn != "" {
+		return fmt.Errorf("Recall %s on car %s is already remedied", recallId, carId)
+	}
+
+	if remedy == "" {
+		return fmt.Errorf("Remedy must be described")
+	}
+
+	notice.Remedy = remedy
+	notice.RemediedBy = dealerId
+	notice.RemediedOn, err = txDate(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := putAsset(ctx, recallNoticeType, []string{recallId, carId}, notice); err != nil {
+		return err
+	}
+
+	car, err := s.QueryCar(ctx, carId)
+	if err != nil {
+		return err
+	}
+
+	openRecalls := []string{}
+	for _, open := range car.OpenRecalls {
+		if open != recallId {
+			openRecalls = append(openRecalls, open)
+		}
+	}
+	car.OpenRecalls = openRecalls
+
+	if err := s.putCar(ctx, carId, car); err != nil {
+		return err
+	}
+
+	recall, err := s.QueryRecall(ctx, recallId)
+	if err != nil {
+		return err
+	}
+
+	recall.RemediedCount++
+	settled, err := s.recallSettled(ctx, recall)
+	if err != nil {
+		return err
+	}
+	if settled {
+		recall.Status = recallClosed
+	}
+
+	return putAsset(ctx, recallType, []string{recallId}, recall)
+}
+
+// recallSettled reports whether every car the recall affects is remedied or can no longer be remedied by a dealer,
+// because it was scrapped, was lost, or is back with the manufacturer with no dealer holding it
+func (s *CarChainCode) recallSettled(ctx contractapi.TransactionContextInterface, recall *Recall) (bool, error) {
+	for _, carId := range recall.AffectedCarIds {
+		notice := new(RecallNotice)
+		if _, err := getAsset(ctx, recallNoticeType, []string{recall.RecallId, carId}, notice); err != nil {
+			return false, err
+		}
+
+		if notice.RemediedOn != "" {
+			continue
+		}
+
+		car, err := s.QueryCar(ctx, carId)
+		if err != nil {
+			return false, err
+		}
+
+		if car.Status != statusScrapped && car.Status != statusLost && car.DealerId != "" {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// CloseRecall lets the issuing manufacturer close a recall whose remaining cars can no longer be remedied,
+// such as a car scrapped after the last remedy was recorded. Unremedied cars keep the recall in openRecalls.
+func (s *CarChainCode) CloseRecall(ctx contractapi.TransactionContextInterface, recallId string) error {
+	if err := s.requireRole(ctx, roleManufacturer); err != nil {
+		return err
+	}
+
+	manufacturerId, err := registeredSubmitter(ctx, roleManufacturer)
+	if err != nil {
+		return err
+	}
+
+	recall, err := s.QueryRecall(ctx, recallId)
+	if err != nil {
+		return err
+	}
+
+	if recall.ManufacturerId != manufacturerId {
+		return fmt.Errorf("Recall %s was not issued by %s", recallId, manufacturerId)
+	}
+
+	if recall.Status == recallClosed {
+		return fmt.Errorf("Recall %s is already closed", recallId)
+	}
+
+	settled, err := s.recallSettled(ctx, recall)
+	if err != nil {
+		return err
+	}
+
+	if !settled {
+		return fmt.Errorf("Recall %s still has cars a dealer can remedy", recallId)
+	}
+
+	recall.Status = recallClosed
+
+	return putAsset(ctx, recallType, []string{recallId}, recall)
+}
+
+// QueryOpenRecallsByDealer returns the unremedied recall notices on cars held by the dealer
+func (s *CarChainCode) QueryOpenRecallsByDealer(ctx contractapi.TransactionContextInterface, dealerId string) ([]RecallNotice, error) {
+	cars, err := s.QueryCarsByIndex(ctx, CarFilter{DealerId: dealerId})
+	if err != nil {
+		return nil, err
+	}
+
+	notices := []RecallNotice{}
+	for _, result := range cars {
+		for _, recallId := range result.Record.OpenRecalls {
+			notice := RecallNotice{}
+			found, err := getAsset(ctx, recallNoticeType, []string{recallId, result.Record.CarId}, &notice)
+			if err != nil {
+				return nil, err
+			}
+
+			if found {
+				notices = append(notices, notice)
+			}
+		}
+	}
+
+	return notices, nil
+}
+
+// requireNoOpenRecalls fails if the car has recalls whose remedy is not recorded yet
+func requireNoOpenRecalls(car *Car) error {
+	if len(car.OpenRecalls) > 0 {
+		return fmt.Errorf("Car %s has open recalls %v", car.CarId, car.OpenRecalls)
+	}
+
+	return nil
+}
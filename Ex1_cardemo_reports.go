@@ -0,0 +1,164 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// SalesAggregate sums the prices of one group of cars. Manufacturer and shipping prices count cars shipped in the
+// date range; customer prices and the dealer margin count each sale in the range, so a car resold after a trade-in
+// counts once per sale. A resale's margin is its whole customer price, as the dealer paid nothing on the ledger for it.
+type SalesAggregate struct {
+	Group             string `json:"group"`
+	ShippedCount      int    `json:"shippedCount"`
+	SoldCount         int    `json:"soldCount"`
+	ManufacturerPrice int    `json:"manufacturerPrice"`
+	ShippingPrice     int    `json:"shippingPrice"`
+	CustomerPrice     int    `json:"customerPrice"`
+	DealerMargin      int    `json:"dealerMargin"`
+}
+
+// inDateRange reports whether the date falls between from and to, both inclusive; an empty bound is open
+func inDateRange(date string, from string, to string) bool {
+	if _, err := time.Parse(dateLayout, date); err != nil {
+		return false
+	}
+
+	if from != "" && dateBefore(date, from) {
+		return false
+	}
+
+	if to != "" && dateBefore(to, date) {
+		return false
+	}
+
+	return true
+}
+
+// findPrivate reads a private record into record, reporting false when the car has none
+func findPrivate(ctx contractapi.TransactionContextInterface, collection string, carId string, record interface{}) (bool, error) {
+	recordAsBytes, err := ctx.GetStub().GetPrivateData(collection, carId)
+	if err != nil {
+		return false, fmt.Errorf("Failed to read prices from %s. %s", collection, err.Error())
+	}
+
+	if recordAsBytes == nil {
+		return false, nil
+	}
+
+	if err := json.Unmarshal(recordAsBytes, record); err != nil {
+		return false, fmt.Errorf("Failed to decode prices of %s. %s", carId, err.Error())
+	}
+
+	return true, nil
+}
+
+// salesReport groups every shipment and sale by the given field and sums its private prices over the date range.
+// The group is computed from the car and the dealer the car was shipped to or sold by.
+// It reads both price collections, so it must be evaluated on a peer that is a member of both.
+func (s *CarChainCode) salesReport(ctx contractapi.TransactionContextInterface, group func(car *Car, dealerId string) string, from string, to string) ([]SalesAggregate, error) {
+	if err := s.requireRole(ctx, roleAdmin); err != nil {
+		return nil, err
+	}
+
+	if err := requireCollectionMember(ctx); err != nil {
+		return nil, err
+	}
+
+	for name, value := range map[string]string{"from": from, "to": to} {
+		if value != "" {
+			if err := validateDate(name, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	cars, err := s.QueryAllCars(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	aggregates := map[string]*SalesAggregate{}
+	aggregateOf := func(key string) *SalesAggregate {
+		aggregate, ok := aggregates[key]
+		if !ok {
+			aggregate = &SalesAggregate{Group: key}
+			aggregates[key] = aggregate
+		}
+
+		return aggregate
+	}
+
+	for _, result := range cars {
+		car := result.Record
+
+		cost := ManufacturerDealerPrice{}
+		if _, err := findPrivate(ctx, manufacturerDealerCollection, car.CarId, &cost); err != nil {
+			return nil, err
+		}
+
+		if inDateRange(car.ShippingDate, from, to) {
+			shippedTo := cost.DealerId
+			if shippedTo == "" {
+				shippedTo = car.DealerId
+			}
+
+			aggregate := aggregateOf(group(car, shippedTo))
+			aggregate.ShippedCount++
+			aggregate.ManufacturerPrice += cost.ManufacturerPrice
+			aggregate.ShippingPrice += cost.ShippingPrice
+		}
+
+		sales, err := carSales(ctx, car)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, sale := range sales {
+			if !inDateRange(sale.SoldOnDate, from, to) {
+				continue
+			}
+
+			aggregate := aggregateOf(group(car, sale.DealerId))
+			aggregate.SoldCount++
+			aggregate.CustomerPrice += sale.CustomerPrice
+			aggregate.DealerMargin += sale.CustomerPrice
+			if !sale.Resale {
+				aggregate.DealerMargin -= cost.ManufacturerPrice + cost.ShippingPrice
+			}
+		}
+	}
+
+	report := []SalesAggregate{}
+	for _, aggregate := range aggregates {
+		report = append(report, *aggregate)
+	}
+
+	sort.Slice(report, func(i, j int) bool {
+		return report[i].Group < report[j].Group
+	})
+
+	return report, nil
+}
+
+// QuerySalesByManufacturer returns the sales aggregates of each manufacturer between from and to, which may be empty
+func (s *CarChainCode) QuerySalesByManufacturer(ctx contractapi.TransactionContextInterface, from string, to string) ([]SalesAggregate, error) {
+	return s.salesReport(ctx, func(car *Car, dealerId string) string { return car.ManufacturerId }, from, to)
+}
+
+// QuerySalesByDealer returns the sales aggregates of each dealer between from and to, which may be empty
+func (s *CarChainCode) QuerySalesByDealer(ctx contractapi.TransactionContextInterface, from string, to string) ([]SalesAggregate, error) {
+	return s.salesReport(ctx, func(car *Car, dealerId string) string { return dealerId }, from, to)
+}
+
+// QuerySalesByModel returns the sales aggregates of each car model between from and to, which may be empty
+func (s *CarChainCode) QuerySalesByModel(ctx contractapi.TransactionContextInterface, from string, to string) ([]SalesAggregate, error) {
+	return s.salesReport(ctx, func(car *Car, dealerId string) string { return car.CarModel }, from, to)
+}
@@ -0,0 +1,150 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// currentSchemaVersion is the Car schema version this chaincode writes.
+// Bump it with every change to the Car struct, register the upgrade from the previous version in carUpgrades,
+// and make the same change to the API's Car in Ex2_cardemo_api.go.
+const currentSchemaVersion = 4
+
+// carUpgrades maps a schema version to the function upgrading a Car record of that version to the next one.
+// Records written before versioning have version 0.
+var carUpgrades = map[int]func(car *Car){
+	0: upgradeCarToV1,
+	1: upgradeCarToV2,
+	2: upgradeCarToV3,
+	3: upgradeCarToV4,
+}
+
+// MigrationResult reports one page of MigrateAll
+type MigrationResult struct {
+	Migrated            int    `json:"migrated"`
+	FetchedRecordsCount int32  `json:"fetchedRecordsCount"`
+	Bookmark            string `json:"bookmark"`
+}
+
+// upgradeCarToV1 tags the record as a car and starts the owner chain of cars sold before chains were recorded
+func upgradeCarToV1(car *Car) {
+	car.DocType = carDocType
+	car.Owners = ownersOf(car)
+}
+
+// upgradeCarToV2 adds the scrapping fields, which stay empty on cars written before scrapping existed
+func upgradeCarToV2(car *Car) {}
+
+// upgradeCarToV3 adds the odometer fields. Cars written before readings were recorded start at zero and unflagged.
+func upgradeCarToV3(car *Car) {}
+
+// upgradeCarToV4 adds the lien fields, which stay empty on cars written before liens could be placed
+func upgradeCarToV4(car *Car) {}
+
+// upgradeCar runs the registered upgrades until the car reaches currentSchemaVersion and reports whether it changed
+func upgradeCar(car *Car) (bool, error) {
+	if car.SchemaVersion > currentSchemaVersion {
+		return false, fmt.Errorf("Car %s has schema version %d, newer than the supported %d", car.CarId, car.SchemaVersion, currentSchemaVersion)
+	}
+
+	upgraded := false
+	for car.SchemaVersion < currentSchemaVersion {
+		upgrade, ok := carUpgrades[car.SchemaVersion]
+		if !ok {
+			return false, fmt.Errorf("No upgrade registered for Car schema version %d", car.SchemaVersion)
+		}
+
+		upgrade(car)
+		car.SchemaVersion++
+		upgraded = true
+	}
+
+	return upgraded, nil
+}
+
+// unmarshalCar decodes a stored Car record and upgrades it to currentSchemaVersion in memory.
+// The upgraded record is written back the next time the car is saved, or by MigrateAll.
+func unmarshalCar(carAsBytes []byte) (*Car, error) {
+	car := new(Car)
+	if err := json.Unmarshal(carAsBytes, car); err != nil {
+		return nil, fmt.Errorf("Failed to decode car. %s", err.Error())
+	}
+
+	if _, err := upgradeCar(car); err != nil {
+		return nil, err
+	}
+
+	return car, nil
+}
+
+// MigrateAll rewrites up to pageSize cars at currentSchemaVersion, starting at the car id in bookmark. Call it again
+// with the returned bookmark, the id of the next car, until the bookmark is empty.
+// Fabric refuses writes after a paginated query, so the page is counted here over a plain range query.
+func (s *CarChainCode) MigrateAll(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*MigrationResult, error) {
+	if err := s.requireRole(ctx, roleAdmin); err != nil {
+		return nil, err
+	}
+
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("Page size must be positive, got %d", pageSize)
+	}
+
+	startKey := ""
+	if bookmark != "" {
+		key, err := carKey(ctx, bookmark)
+		if err != nil {
+			return nil, err
+		}
+		startKey = key
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(carKeyType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	result := &MigrationResult{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		if queryResponse.Key < startKey {
+			continue
+		}
+
+		if result.FetchedRecordsCount == pageSize {
+			result.Bookmark = carIdOf(ctx, queryResponse.Key)
+			break
+		}
+		result.FetchedRecordsCount++
+
+		car := new(Car)
+		if err := json.Unmarshal(queryResponse.Value, car); err != nil {
+			return nil, fmt.Errorf("Failed to decode car %s. %s", queryResponse.Key, err.Error())
+		}
+
+		upgraded, err := upgradeCar(car)
+		if err != nil {
+			return nil, err
+		}
+
+		if !upgraded {
+			continue
+		}
+
+		if err := s.putCar(ctx, carIdOf(ctx, queryResponse.Key), car); err != nil {
+			return nil, err
+		}
+		result.Migrated++
+	}
+
+	return result, nil
+}
@@ -0,0 +1,76 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// validateCertificateHash fails unless the hash is a hex encoded SHA-256 digest
+func validateCertificateHash(certificateHash string) error {
+	digest, err := hex.DecodeString(certificateHash)
+	if err != nil || len(digest) != 32 {
+		return fmt.Errorf("Certificate of destruction hash must be a hex encoded SHA-256 digest, got %q", certificateHash)
+	}
+
+	return nil
+}
+
+// withoutScrapped drops scrapped cars from query results
+func withoutScrapped(results []QueryResult) []QueryResult {
+	kept := []QueryResult{}
+	for _, result := range results {
+		if result.Record.Status != statusScrapped {
+			kept = append(kept, result)
+		}
+	}
+
+	return kept
+}
+
+// ScrapCar lets a recycler or regulator deregister a totalled or end-of-life car, recording the hash of its certificate of destruction.
+// A scrapped car never changes status again and any pending transfer offer is withdrawn, but its history stays queryable.
+// A car under an active lien cannot be scrapped until the lender releases it.
+func (s *CarChainCode) ScrapCar(ctx contractapi.TransactionContextInterface, carId string, certificateHash string, odometer int) error {
+	if err := s.requireRole(ctx, roleRecycler, roleRegulator); err != nil {
+		return err
+	}
+
+	if err := validateCertificateHash(certificateHash); err != nil {
+		return err
+	}
+
+	car, err := s.QueryCar(ctx, carId)
+	if err != nil {
+		return err
+	}
+	if err := requireNoActiveLien(car); err != nil {
+		return err
+	}
+	if err := transition(car, statusScrapped); err != nil {
+		return err
+	}
+	car.DestructionCertificateHash = strings.ToLower(certificateHash)
+	car.ScrappedOnDate, err = txDate(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := s.recordOdometer(ctx, car, odometer, car.Status); err != nil {
+		return err
+	}
+
+	key, err := transferOfferKey(ctx, carId)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().DelState(key); err != nil {
+		return fmt.Errorf("Failed to delete transfer offer. %s", err.Error())
+	}
+
+	return s.putCar(ctx, carId, car)
+}
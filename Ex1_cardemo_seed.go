@@ -0,0 +1,194 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// compositeKeyNamespace starts every composite key
+const compositeKeyNamespace = "\x00"
+
+// transientSeedKey is the transient map entry carrying an optional LedgerSeed as JSON
+const transientSeedKey = "seed"
+
+// LedgerSeed is the document InitLedger loads
+type LedgerSeed struct {
+	Participants []Participant `json:"participants"`
+	Cars         []Car         `json:"cars"`
+}
+
+// defaultLedgerSeed is the base set of participants and cars loaded when no seed is passed
+func defaultLedgerSeed() LedgerSeed {
+	return LedgerSeed{
+		Participants: []Participant{
+			Participant{ParticipantType: roleManufacturer, ParticipantId: "MOrg01", Name: "Manufacturer Org 1", MSPID: "Org1MSP"},
+			Participant{ParticipantType: roleManufacturer, ParticipantId: "MOrg02", Name: "Manufacturer Org 2", MSPID: "Org1MSP"},
+			Participant{ParticipantType: roleManufacturer, ParticipantId: "MOrg03", Name: "Manufacturer Org 3", MSPID: "Org1MSP"},
+			Participant{ParticipantType: roleDealer, ParticipantId: "D101", Name: "Dealer 101", MSPID: "Org2MSP"},
+			Participant{ParticipantType: roleDealer, ParticipantId: "D102", Name: "Dealer 102", MSPID: "Org2MSP"},
+			Participant{ParticipantType: roleConsumer, ParticipantId: "CUST101", Name: "Customer 101", MSPID: "Org3MSP"},
+			Participant{ParticipantType: roleConsumer, ParticipantId: "CUST102", Name: "Customer 102", MSPID: "Org3MSP"},
+			Participant{ParticipantType: roleConsumer, ParticipantId: "CUST103", Name: "Customer 103", MSPID: "Org3MSP"},
+		},
+		Cars: []Car{
+			Car{ManufacturerId: "MOrg01", CarId: "M101", DealerId: "D101", ConsumerId: "CUST101", CarMake: "2022", CarModel: "MOrg01CM101", CarColor: "Red", Status: statusSold, ManufacturingDate: "2022-01-01T00:00:00Z", ShippingDate: "2022-02-01T00:00:00Z", DeliveryDate: "2022-02-20T00:00:00Z", SoldOnDate: "2022-04-20T00:00:00Z"},
+			Car{ManufacturerId: "MOrg01", CarId: "M102", DealerId: "D102", ConsumerId: "CUST102", CarMake: "2022", CarModel: "MOrg01CM102", CarColor: "Blue", Status: statusSold, ManufacturingDate: "2022-01-01T00:00:00Z", ShippingDate: "2022-02-01T00:00:00Z", DeliveryDate: "2022-02-20T00:00:00Z", SoldOnDate: "2022-04-20T00:00:00Z"},
+			Car{ManufacturerId: "MOrg02", CarId: "M103", DealerId: "D102", ConsumerId: "CUST103", CarMake: "2022", CarModel: "MOrg02CM103", CarColor: "Blue", Status: statusSold, ManufacturingDate: "2022-01-01T00:00:00Z", ShippingDate: "2022-02-01T00:00:00Z", DeliveryDate: "2022-02-20T00:00:00Z", SoldOnDate: "2022-04-20T00:00:00Z"},
+			Car{ManufacturerId: "MOrg02", CarId: "M104", DealerId: "D101", ConsumerId: "CUST101", CarMake: "2022", CarModel: "MOrg01CM101", CarColor: "Red", Status: statusSold, ManufacturingDate: "2022-01-01T00:00:00Z", ShippingDate: "2022-02-01T00:00:00Z", DeliveryDate: "2022-02-20T00:00:00Z", SoldOnDate: "2022-04-20T00:00:00Z"},
+		},
+	}
+}
+
+// InitLedger adds a base set of participants and cars to the ledger. A different LedgerSeed may be passed
+// in the transient map under "seed" by an admin. Participants and cars that already exist are left untouched,
+// so InitLedger can be run again safely.
+func (s *CarChainCode) InitLedger(ctx contractapi.TransactionContextInterface) error {
+	seed := defaultLedgerSeed()
+
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return fmt.Errorf("Failed to read transient map. %s", err.Error())
+	}
+
+	if seedAsBytes, ok := transientMap[transientSeedKey]; ok {
+		if err := s.requireRole(ctx, roleAdmin); err != nil {
+			return err
+		}
+
+		seed = LedgerSeed{}
+		if err := json.Unmarshal(seedAsBytes, &seed); err != nil {
+			return fmt.Errorf("Failed to decode seed. %s", err.Error())
+		}
+	}
+
+	for _, participant := range seed.Participants {
+		if err := validateParticipantType(participant.ParticipantType); err != nil {
+			return err
+		}
+
+		existing := new(Participant)
+		found, err := getAsset(ctx, participantDocType, []string{participant.ParticipantType, participant.ParticipantId}, existing)
+		if err != nil {
+			return err
+		}
+
+		if found {
+			continue
+		}
+
+		if participant.MSPID == "" {
+			return fmt.Errorf("Seed participant %s must set mspId", participant.ParticipantId)
+		}
+
+		participant.DocType = participantDocType
+		participant.Active = true
+		err = putAsset(ctx, participantDocType, []string{participant.ParticipantType, participant.ParticipantId}, participant)
+
+		if err != nil {
+			return fmt.Errorf("Failed Participant data to put to world state. %s", err.Error())
+		}
+	}
+
+	for _, car := range seed.Cars {
+		if car.CarId == "" {
+			return fmt.Errorf("Seed car must set carId")
+		}
+
+		key, err := carKey(ctx, car.CarId)
+		if err != nil {
+			return err
+		}
+
+		existing, err := ctx.GetStub().GetState(key)
+		if err != nil {
+			return fmt.Errorf("Failed to read from world state. %s", err.Error())
+		}
+
+		if existing != nil {
+			continue
+		}
+
+		err = s.putCar(ctx, car.CarId, &car)
+
+		if err != nil {
+			return fmt.Errorf("Failed Car data to put to world state. %s", err.Error())
+		}
+	}
+
+	return nil
+}
+
+// MigrateKeys moves every car still stored under a plain key, such as the "CAR0" keys of earlier seeds,
+// to the CAR~carId composite key and returns how many cars moved. The old key's history stays on the ledger.
+func (s *CarChainCode) MigrateKeys(ctx contractapi.TransactionContextInterface) (int, error) {
+	if err := s.requireRole(ctx, roleAdmin); err != nil {
+		return 0, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	legacy := map[string]*Car{}
+	legacyKeys := []string{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+
+		// composite keys already follow the new scheme
+		if strings.HasPrefix(queryResponse.Key, compositeKeyNamespace) {
+			continue
+		}
+
+		car := new(Car)
+		if err := json.Unmarshal(queryResponse.Value, car); err != nil || car.CarId == "" || (car.DocType != "" && car.DocType != carDocType) {
+			continue
+		}
+
+		legacy[queryResponse.Key] = car
+		legacyKeys = append(legacyKeys, queryResponse.Key)
+	}
+
+	for _, oldKey := range legacyKeys {
+		car := legacy[oldKey]
+
+		key, err := carKey(ctx, car.CarId)
+		if err != nil {
+			return 0, err
+		}
+
+		existing, err := ctx.GetStub().GetState(key)
+		if err != nil {
+			return 0, fmt.Errorf("Failed to read from world state. %s", err.Error())
+		}
+
+		if existing != nil {
+			return 0, fmt.Errorf("Cannot move %s: car %s already exists", oldKey, car.CarId)
+		}
+
+		// drop the index entries that point at the old key before writing the car under its new one
+		if err := updateCarIndexes(ctx, oldKey, car, &Car{}); err != nil {
+			return 0, err
+		}
+
+		if err := ctx.GetStub().DelState(oldKey); err != nil {
+			return 0, fmt.Errorf("Failed to delete %s. %s", oldKey, err.Error())
+		}
+
+		if err := s.putCar(ctx, car.CarId, car); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(legacyKeys), nil
+}
@@ -0,0 +1,146 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// serviceRecordType is the composite key object type of service records, keyed by carId then txId
+const serviceRecordType = "serviceRecord"
+
+// ServiceRecordInput is the input of AddServiceRecord
+type ServiceRecordInput struct {
+	CarId         string   `json:"carId"`
+	ServiceDate   string   `json:"serviceDate"`
+	Odometer      int      `json:"odometer"`
+	WorkPerformed string   `json:"workPerformed"`
+	PartsReplaced []string `json:"partsReplaced" metadata:",optional"`
+	Cost          int      `json:"cost"`
+}
+
+// ServiceRecord is one maintenance visit appended to a car by a service centre
+type ServiceRecord struct {
+	DocType         string   `json:"docType"`
+	CarId           string   `json:"carId"`
+	ServiceCenterId string   `json:"serviceCenterId"`
+	ServiceDate     string   `json:"serviceDate"`
+	Odometer        int      `json:"odometer"`
+	WorkPerformed   string   `json:"workPerformed"`
+	PartsReplaced   []string `json:"partsReplaced"`
+	Cost            int      `json:"cost"`
+	RecordedOn      string   `json:"recordedOn"`
+	TxId            string   `json:"txId"`
+}
+
+// AddServiceRecord appends a maintenance record to a car that has been sold and records the visit's odometer reading
+func (s *CarChainCode) AddServiceRecord(ctx contractapi.TransactionContextInterface, input ServiceRecordInput) (*ServiceRecord, error) {
+	if err := s.requireRole(ctx, roleServiceCenter); err != nil {
+		return nil, err
+	}
+
+	serviceCenterId, err := submitterParticipant(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	car, err := s.QueryCar(ctx, input.CarId)
+	if err != nil {
+		return nil, err
+	}
+
+	if car.Status != statusSold && car.Status != statusTradedIn {
+		return nil, fmt.Errorf("Car %s is %s and can only be serviced once sold", input.CarId, car.Status)
+	}
+
+	if err := validateDate("serviceDate", input.ServiceDate); err != nil {
+		return nil, err
+	}
+
+	if input.WorkPerformed == "" {
+		return nil, fmt.Errorf("Work performed must be described")
+	}
+
+	if input.Odometer < 0 || input.Cost < 0 {
+		return nil, fmt.Errorf("Odometer and cost cannot be negative")
+	}
+
+	recordedOn, err := txDate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	partsReplaced := input.PartsReplaced
+	if partsReplaced == nil {
+		partsReplaced = []string{}
+	}
+
+	record := ServiceRecord{
+		DocType:         serviceRecordType,
+		CarId:           input.CarId,
+		ServiceCenterId: serviceCenterId,
+		ServiceDate:     input.ServiceDate,
+		Odometer:        input.Odometer,
+		WorkPerformed:   input.WorkPerformed,
+		PartsReplaced:   partsReplaced,
+		Cost:            input.Cost,
+		RecordedOn:      recordedOn,
+		TxId:            ctx.GetStub().GetTxID(),
+	}
+
+	if err := putAsset(ctx, serviceRecordType, []string{record.CarId, record.TxId}, record); err != nil {
+		return nil, err
+	}
+
+	reading, err := s.recordOdometer(ctx, car, input.Odometer, odometerSourceService)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.putCarWithReading(ctx, car, reading); err != nil {
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+// QueryServiceRecords returns the car's maintenance records ordered by service date
+func (s *CarChainCode) QueryServiceRecords(ctx contractapi.TransactionContextInterface, carId string) ([]ServiceRecord, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(serviceRecordType, []string{carId})
+
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	records := []ServiceRecord{}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		record := ServiceRecord{}
+		if err := json.Unmarshal(queryResponse.Value, &record); err != nil {
+			return nil, fmt.Errorf("Failed to decode service record %s. %s", queryResponse.Key, err.Error())
+		}
+		records = append(records, record)
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].ServiceDate != records[j].ServiceDate {
+			return dateBefore(records[i].ServiceDate, records[j].ServiceDate)
+		}
+
+		return dateBefore(records[i].RecordedOn, records[j].RecordedOn)
+	})
+
+	return records, nil
+}
@@ -0,0 +1,420 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Composite key object types of the settlement token
+const (
+	tokenAccountType   = "tokenAccount"
+	tokenAllowanceType = "tokenAllowance"
+	tokenPaymentType   = "tokenPayment"
+)
+
+// Shipment payment states
+const (
+	paymentPaid     = "PAID"
+	paymentRefunded = "REFUNDED"
+)
+
+// settlementCollection is the private data collection holding every participant's token account, allowances and
+// shipment payments. It is shared by the manufacturer, dealer and consumer organizations, so each participant holds
+// one balance and a dealer pays manufacturers out of what consumers paid it.
+const settlementCollection = "settlementTokens"
+
+// transientSaltKey is the transient map entry carrying the salt of the token records Mint, Transfer and Approve create
+const transientSaltKey = "salt"
+
+// TokenAccount holds a participant's balance of the settlement token.
+// Salt is mixed into the private record so the hash on the public ledger cannot be brute forced.
+type TokenAccount struct {
+	DocType   string `json:"docType"`
+	OwnerType string `json:"ownerType"`
+	OwnerId   string `json:"ownerId"`
+	Balance   int    `json:"balance"`
+	Salt      string `json:"salt"`
+}
+
+// TokenAllowance is how much a spender may still settle out of an owner's account
+type TokenAllowance struct {
+	DocType     string `json:"docType"`
+	OwnerType   string `json:"ownerType"`
+	OwnerId     string `json:"ownerId"`
+	SpenderType string `json:"spenderType"`
+	SpenderId   string `json:"spenderId"`
+	Amount      int    `json:"amount"`
+	Salt        string `json:"salt"`
+}
+
+// ShipmentPayment records what a dealer paid the manufacturer when its car was shipped, so the payment can be
+// reversed if the car is rejected, lost or returned
+type ShipmentPayment struct {
+	DocType   string `json:"docType"`
+	CarId     string `json:"carId"`
+	PayerType string `json:"payerType"`
+	PayerId   string `json:"payerId"`
+	PayeeType string `json:"payeeType"`
+	PayeeId   string `json:"payeeId"`
+	Amount    int    `json:"amount"`
+	Status    string `json:"status"`
+	Salt      string `json:"salt"`
+}
+
+// transientSalt reads the salt passed in the transient map
+func transientSalt(ctx contractapi.TransactionContextInterface) (string, error) {
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return "", fmt.Errorf("Failed to read transient map. %s", err.Error())
+	}
+
+	salt, ok := transientMap[transientSaltKey]
+	if !ok || len(salt) == 0 {
+		return "", fmt.Errorf("A salt must be passed in the transient map under %q", transientSaltKey)
+	}
+
+	return string(salt), nil
+}
+
+// getPrivateAsset reads the asset stored in the collection under the composite key and reports whether it exists
+func getPrivateAsset(ctx contractapi.TransactionContextInterface, collection string, objectType string, attributes []string, asset interface{}) (bool, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return false, fmt.Errorf("Failed to create %s key. %s", objectType, err.Error())
+	}
+
+	assetAsBytes, err := ctx.GetStub().GetPrivateData(collection, key)
+	if err != nil {
+		return false, fmt.Errorf("Failed to read from %s. %s", collection, err.Error())
+	}
+
+	if assetAsBytes == nil {
+		return false, nil
+	}
+
+	if err := json.Unmarshal(assetAsBytes, asset); err != nil {
+		return false, fmt.Errorf("Failed to decode %s. %s", objectType, err.Error())
+	}
+
+	return true, nil
+}
+
+// putPrivateAsset writes the asset as JSON to the collection under the composite key
+func putPrivateAsset(ctx contractapi.TransactionContextInterface, collection string, objectType string, attributes []string, asset interface{}) error {
+	key, err := ctx.GetStub().CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return fmt.Errorf("Failed to create %s key. %s", objectType, err.Error())
+	}
+
+	assetAsBytes, _ := json.Marshal(asset)
+
+	if err := ctx.GetStub().PutPrivateData(collection, key, assetAsBytes); err != nil {
+		return fmt.Errorf("Failed to put %s to %s. %s", objectType, collection, err.Error())
+	}
+
+	return nil
+}
+
+// getTokenAccount returns the participant's account, empty if it holds no tokens yet
+func getTokenAccount(ctx contractapi.TransactionContextInterface, ownerType string, ownerId string) (*TokenAccount, error) {
+	account := &TokenAccount{DocType: tokenAccountType, OwnerType: ownerType, OwnerId: ownerId}
+	if _, err := getPrivateAsset(ctx, settlementCollection, tokenAccountType, []string{ownerType, ownerId}, account); err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// putTokenAccount writes the account, salting it with the given salt when it is new
+func putTokenAccount(ctx contractapi.TransactionContextInterface, account *TokenAccount, salt string) error {
+	if account.Salt == "" {
+		account.Salt = salt
+	}
+
+	return putPrivateAsset(ctx, settlementCollection, tokenAccountType, []string{account.OwnerType, account.OwnerId}, account)
+}
+
+// moveTokens debits one account and credits another, failing if the debited account cannot cover the amount.
+// New accounts are salted with the given salt.
+func moveTokens(ctx contractapi.TransactionContextInterface, fromType string, fromId string, toType string, toId string, amount int, salt string) error {
+	if amount <= 0 {
+		return fmt.Errorf("Amount must be positive, got %d", amount)
+	}
+
+	if fromType == toType && fromId == toId {
+		return fmt.Errorf("Cannot transfer tokens from %s %s to itself", fromType, fromId)
+	}
+
+	from, err := getTokenAccount(ctx, fromType, fromId)
+	if err != nil {
+		return err
+	}
+
+	if from.Balance < amount {
+		return fmt.Errorf("The %s %s holds %d tokens and cannot pay %d", fromType, fromId, from.Balance, amount)
+	}
+
+	to, err := getTokenAccount(ctx, toType, toId)
+	if err != nil {
+		return err
+	}
+
+	from.Balance -= amount
+	to.Balance += amount
+
+	if err := putTokenAccount(ctx, from, salt); err != nil {
+		return err
+	}
+
+	return putTokenAccount(ctx, to, salt)
+}
+
+// spendAllowance takes the amount out of the allowance the payer granted the payee, failing if it does not cover it
+func spendAllowance(ctx contractapi.TransactionContextInterface, payerType string, payerId string, payeeType string, payeeId string, amount int, salt string) error {
+	allowance := &TokenAllowance{DocType: tokenAllowanceType, OwnerType: payerType, OwnerId: payerId, SpenderType: payeeType, SpenderId: payeeId, Salt: salt}
+	if _, err := getPrivateAsset(ctx, settlementCollection, tokenAllowanceType, []string{payerType, payerId, payeeType, payeeId}, allowance); err != nil {
+		return err
+	}
+
+	if allowance.Amount < amount {
+		return fmt.Errorf("The %s %s has approved %d tokens for %s %s, not %d", payerType, payerId, allowance.Amount, payeeType, payeeId, amount)
+	}
+
+	allowance.Amount -= amount
+
+	return putPrivateAsset(ctx, settlementCollection, tokenAllowanceType, []string{payerType, payerId, payeeType, payeeId}, allowance)
+}
+
+// settle moves the amount from the payer to the payee out of the allowance the payer granted the payee.
+// It runs inside the life cycle transaction, so the payment and the status change commit or fail together,
+// and it only writes to the settlement collection, salted with the transaction's price salt.
+func settle(ctx contractapi.TransactionContextInterface, payerType string, payerId string, payeeType string, payeeId string, amount int, salt string) error {
+	if amount == 0 {
+		return nil
+	}
+
+	if err := spendAllowance(ctx, payerType, payerId, payeeType, payeeId, amount, salt); err != nil {
+		return err
+	}
+
+	return moveTokens(ctx, payerType, payerId, payeeType, payeeId, amount, salt)
+}
+
+// payForShipment settles the amount from the dealer to the manufacturer when the car is shipped, and records the
+// payment so refundShipment can reverse it
+func payForShipment(ctx contractapi.TransactionContextInterface, carId string, dealerId string, manufacturerId string, amount int, salt string) error {
+	if amount == 0 {
+		return nil
+	}
+
+	if err := settle(ctx, roleDealer, dealerId, roleManufacturer, manufacturerId, amount, salt); err != nil {
+		return err
+	}
+
+	payment := ShipmentPayment{
+		DocType:   tokenPaymentType,
+		CarId:     carId,
+		PayerType: roleDealer,
+		PayerId:   dealerId,
+		PayeeType: roleManufacturer,
+		PayeeId:   manufacturerId,
+		Amount:    amount,
+		Status:    paymentPaid,
+		Salt:      salt,
+	}
+
+	return putPrivateAsset(ctx, settlementCollection, tokenPaymentType, []string{carId}, payment)
+}
+
+// getShipmentPayment returns the payment for the car's latest shipment, nil if it was never paid for
+func getShipmentPayment(ctx contractapi.TransactionContextInterface, carId string) (*ShipmentPayment, error) {
+	payment := new(ShipmentPayment)
+	found, err := getPrivateAsset(ctx, settlementCollection, tokenPaymentType, []string{carId}, payment)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return payment, nil
+}
+
+// refundShipment has the manufacturer pay the dealer back for a car that is rejected, lost or returned,
+// so shipping the car again does not charge the dealer twice
+func refundShipment(ctx contractapi.TransactionContextInterface, carId string) error {
+	payment, err := getShipmentPayment(ctx, carId)
+	if err != nil || payment == nil || payment.Status == paymentRefunded {
+		return err
+	}
+
+	if err := moveTokens(ctx, payment.PayeeType, payment.PayeeId, payment.PayerType, payment.PayerId, payment.Amount, payment.Salt); err != nil {
+		return err
+	}
+
+	payment.Status = paymentRefunded
+
+	return putPrivateAsset(ctx, settlementCollection, tokenPaymentType, []string{carId}, payment)
+}
+
+// requireTokenReader fails unless the submitter is an admin, the account's owner or, for allowances, its spender,
+// so participants sharing the settlement collection cannot read each other's balances and allowances
+func (s *CarChainCode) requireTokenReader(ctx contractapi.TransactionContextInterface, ownerType string, ownerId string, spenderType string, spenderId string) error {
+	role, err := s.submitterRole(ctx)
+	if err != nil {
+		return err
+	}
+
+	if role == roleAdmin {
+		return nil
+	}
+
+	if err := validateParticipantType(role); err != nil {
+		return err
+	}
+
+	submitterId, err := registeredSubmitter(ctx, role)
+	if err != nil {
+		return err
+	}
+
+	if (role == ownerType && submitterId == ownerId) || (role == spenderType && submitterId == spenderId) {
+		return nil
+	}
+
+	return fmt.Errorf("The %s %s may not read the tokens of %s %s", role, submitterId, ownerType, ownerId)
+}
+
+// submitterAccount returns the participant type and id whose tokens the submitter controls
+func (s *CarChainCode) submitterAccount(ctx contractapi.TransactionContextInterface) (string, string, error) {
+	ownerType, err := s.submitterRole(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := validateParticipantType(ownerType); err != nil {
+		return "", "", err
+	}
+
+	ownerId, err := registeredSubmitter(ctx, ownerType)
+	if err != nil {
+		return "", "", err
+	}
+
+	return ownerType, ownerId, nil
+}
+
+// Mint creates new settlement tokens in a registered participant's account.
+// The salt of a new account is passed in the transient map.
+func (s *CarChainCode) Mint(ctx contractapi.TransactionContextInterface, ownerType string, ownerId string, amount int) error {
+	if err := s.requireRole(ctx, roleAdmin); err != nil {
+		return err
+	}
+
+	if amount <= 0 {
+		return fmt.Errorf("Amount must be positive, got %d", amount)
+	}
+
+	if err := requireActiveParticipant(ctx, ownerType, ownerId); err != nil {
+		return err
+	}
+
+	salt, err := transientSalt(ctx)
+	if err != nil {
+		return err
+	}
+
+	account, err := getTokenAccount(ctx, ownerType, ownerId)
+	if err != nil {
+		return err
+	}
+
+	account.Balance += amount
+
+	return putTokenAccount(ctx, account, salt)
+}
+
+// BalanceOf returns the participant's settlement token balance to the participant itself or an admin
+func (s *CarChainCode) BalanceOf(ctx contractapi.TransactionContextInterface, ownerType string, ownerId string) (int, error) {
+	if err := requireCollectionMember(ctx); err != nil {
+		return 0, err
+	}
+
+	if err := s.requireTokenReader(ctx, ownerType, ownerId, "", ""); err != nil {
+		return 0, err
+	}
+
+	account, err := getTokenAccount(ctx, ownerType, ownerId)
+	if err != nil {
+		return 0, err
+	}
+
+	return account.Balance, nil
+}
+
+// Transfer moves settlement tokens from the submitter's account to another registered participant's account.
+// The salt of a new account is passed in the transient map.
+func (s *CarChainCode) Transfer(ctx contractapi.TransactionContextInterface, toType string, toId string, amount int) error {
+	fromType, fromId, err := s.submitterAccount(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := requireActiveParticipant(ctx, toType, toId); err != nil {
+		return err
+	}
+
+	salt, err := transientSalt(ctx)
+	if err != nil {
+		return err
+	}
+
+	return moveTokens(ctx, fromType, fromId, toType, toId, amount, salt)
+}
+
+// Approve sets how much the spender may settle out of the submitter's account when shipping or selling it a car.
+// The salt of the allowance is passed in the transient map.
+func (s *CarChainCode) Approve(ctx contractapi.TransactionContextInterface, spenderType string, spenderId string, amount int) error {
+	ownerType, ownerId, err := s.submitterAccount(ctx)
+	if err != nil {
+		return err
+	}
+
+	if amount < 0 {
+		return fmt.Errorf("Amount cannot be negative, got %d", amount)
+	}
+
+	if err := requireActiveParticipant(ctx, spenderType, spenderId); err != nil {
+		return err
+	}
+
+	salt, err := transientSalt(ctx)
+	if err != nil {
+		return err
+	}
+
+	allowance := TokenAllowance{DocType: tokenAllowanceType, OwnerType: ownerType, OwnerId: ownerId, SpenderType: spenderType, SpenderId: spenderId, Amount: amount, Salt: salt}
+
+	return putPrivateAsset(ctx, settlementCollection, tokenAllowanceType, []string{ownerType, ownerId, spenderType, spenderId}, allowance)
+}
+
+// Allowance returns how much the spender may still settle out of the owner's account, to the owner, the spender
+// or an admin
+func (s *CarChainCode) Allowance(ctx contractapi.TransactionContextInterface, ownerType string, ownerId string, spenderType string, spenderId string) (int, error) {
+	if err := requireCollectionMember(ctx); err != nil {
+		return 0, err
+	}
+
+	if err := s.requireTokenReader(ctx, ownerType, ownerId, spenderType, spenderId); err != nil {
+		return 0, err
+	}
+
+	allowance := new(TokenAllowance)
+	if _, err := getPrivateAsset(ctx, settlementCollection, tokenAllowanceType, []string{ownerType, ownerId, spenderType, spenderId}, allowance); err != nil {
+		return 0, err
+	}
+
+	return allowance.Amount, nil
+}
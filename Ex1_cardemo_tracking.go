@@ -0,0 +1,170 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// checkpointType is the composite key object type of tracking checkpoints, keyed by carId, shipmentId then txId
+const checkpointType = "checkpoint"
+
+// CheckpointInput is the input of RecordCheckpoint
+type CheckpointInput struct {
+	CarId      string `json:"carId"`
+	Leg        int    `json:"leg"`
+	Location   string `json:"location"`
+	Timestamp  string `json:"timestamp"`
+	HandoverTo string `json:"handoverTo" metadata:",optional"`
+}
+
+// Checkpoint is one tracking point a carrier posts on a shipment
+type Checkpoint struct {
+	DocType    string `json:"docType"`
+	CarId      string `json:"carId"`
+	ShipmentId string `json:"shipmentId"`
+	Leg        int    `json:"leg"`
+	Location   string `json:"location"`
+	Timestamp  string `json:"timestamp"`
+	CarrierId  string `json:"carrierId"`
+	HandoverTo string `json:"handoverTo"`
+	TxId       string `json:"txId"`
+}
+
+// RecordCheckpoint lets the carrier holding the car's current shipment post a tracking checkpoint on it.
+// Legs are numbered from 1 and may not go backwards; HandoverTo names the party taking the car over at the checkpoint,
+// who then holds the shipment.
+func (s *CarChainCode) RecordCheckpoint(ctx contractapi.TransactionContextInterface, input CheckpointInput) (*Checkpoint, error) {
+	if err := s.requireRole(ctx, roleCarrier); err != nil {
+		return nil, err
+	}
+
+	carrierId, err := submitterParticipant(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	car, err := s.QueryCar(ctx, input.CarId)
+	if err != nil {
+		return nil, err
+	}
+
+	if car.Status != statusShipped {
+		return nil, fmt.Errorf("Car %s is %s and can only be tracked while SHIPPED", input.CarId, car.Status)
+	}
+
+	if car.ShipmentId == "" {
+		return nil, fmt.Errorf("Car %s was shipped before tracking was recorded", input.CarId)
+	}
+
+	if carrierId != car.CarrierId {
+		return nil, fmt.Errorf("Shipment %s is held by carrier %s, not %s", car.ShipmentId, car.CarrierId, carrierId)
+	}
+
+	if input.Leg < 1 {
+		return nil, fmt.Errorf("Leg must be 1 or more, got %d", input.Leg)
+	}
+
+	if input.Location == "" {
+		return nil, fmt.Errorf("Checkpoint location must be set")
+	}
+
+	if err := validateDate("timestamp", input.Timestamp); err != nil {
+		return nil, err
+	}
+
+	previous, err := s.queryCheckpoints(ctx, input.CarId, car.ShipmentId)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(previous) > 0 {
+		last := previous[len(previous)-1]
+		if input.Leg < last.Leg {
+			return nil, fmt.Errorf("Shipment %s is already on leg %d, got leg %d", car.ShipmentId, last.Leg, input.Leg)
+		}
+		if dateBefore(input.Timestamp, last.Timestamp) {
+			return nil, fmt.Errorf("Checkpoint at %s is before the last checkpoint at %s", input.Timestamp, last.Timestamp)
+		}
+	}
+
+	checkpoint := Checkpoint{
+		DocType:    checkpointType,
+		CarId:      input.CarId,
+		ShipmentId: car.ShipmentId,
+		Leg:        input.Leg,
+		Location:   input.Location,
+		Timestamp:  input.Timestamp,
+		CarrierId:  carrierId,
+		HandoverTo: input.HandoverTo,
+		TxId:       ctx.GetStub().GetTxID(),
+	}
+
+	if err := putAsset(ctx, checkpointType, []string{checkpoint.CarId, checkpoint.ShipmentId, checkpoint.TxId}, checkpoint); err != nil {
+		return nil, err
+	}
+
+	if input.HandoverTo != "" {
+		car.CarrierId = input.HandoverTo
+		if err := s.putCar(ctx, input.CarId, car); err != nil {
+			return nil, err
+		}
+	}
+
+	return &checkpoint, nil
+}
+
+// QueryTracking returns the checkpoints of the car's current shipment, ordered by leg and time
+func (s *CarChainCode) QueryTracking(ctx contractapi.TransactionContextInterface, carId string) ([]Checkpoint, error) {
+	car, err := s.QueryCar(ctx, carId)
+	if err != nil {
+		return nil, err
+	}
+
+	if car.ShipmentId == "" {
+		return []Checkpoint{}, nil
+	}
+
+	return s.queryCheckpoints(ctx, carId, car.ShipmentId)
+}
+
+// queryCheckpoints reads the checkpoints of one shipment, ordered by leg and time
+func (s *CarChainCode) queryCheckpoints(ctx contractapi.TransactionContextInterface, carId string, shipmentId string) ([]Checkpoint, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(checkpointType, []string{carId, shipmentId})
+
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	checkpoints := []Checkpoint{}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		checkpoint := Checkpoint{}
+		if err := json.Unmarshal(queryResponse.Value, &checkpoint); err != nil {
+			return nil, fmt.Errorf("Failed to decode checkpoint %s. %s", queryResponse.Key, err.Error())
+		}
+		checkpoints = append(checkpoints, checkpoint)
+	}
+
+	sort.SliceStable(checkpoints, func(i, j int) bool {
+		if checkpoints[i].Leg != checkpoints[j].Leg {
+			return checkpoints[i].Leg < checkpoints[j].Leg
+		}
+
+		return dateBefore(checkpoints[i].Timestamp, checkpoints[j].Timestamp)
+	})
+
+	return checkpoints, nil
+}
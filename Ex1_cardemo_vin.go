@@ -0,0 +1,227 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// vinLength is the length of an ISO 3779 vehicle identification number
+const vinLength = 17
+
+// wmiType is the composite key object type mapping a world manufacturer identifier to a ManufacturerId
+const wmiType = "wmi"
+
+// vinWeights are the per-position weights of the VIN check digit; position 9 is the check digit itself
+var vinWeights = [vinLength]int{8, 7, 6, 5, 4, 3, 2, 10, 0, 9, 8, 7, 6, 5, 4, 3, 2}
+
+// vinYearCodes lists the model year codes of one 30 year cycle, starting at 1980 (or 2010)
+const vinYearCodes = "ABCDEFGHJKLMNPRSTVWXY123456789"
+
+// VinInfo is what a VIN encodes
+type VinInfo struct {
+	Vin          string `json:"vin"`
+	Wmi          string `json:"wmi"`
+	Vds          string `json:"vds"`
+	CheckDigit   string `json:"checkDigit"`
+	ModelYear    int    `json:"modelYear"`
+	PlantCode    string `json:"plantCode"`
+	SerialNumber string `json:"serialNumber"`
+}
+
+// WmiRegistration maps a world manufacturer identifier to the manufacturer it was assigned to
+type WmiRegistration struct {
+	DocType        string `json:"docType"`
+	Wmi            string `json:"wmi"`
+	ManufacturerId string `json:"manufacturerId"`
+}
+
+// vinValue transliterates a VIN character for the check digit; I, O and Q are not allowed
+func vinValue(c byte) (int, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0'), true
+	case c >= 'A' && c <= 'H':
+		return int(c-'A') + 1, true
+	case c >= 'J' && c <= 'N':
+		return int(c-'J') + 1, true
+	case c == 'P':
+		return 7, true
+	case c == 'R':
+		return 9, true
+	case c >= 'S' && c <= 'Z':
+		return int(c-'S') + 2, true
+	}
+
+	return 0, false
+}
+
+// isVin reports whether the id has the shape of a VIN and should be validated as one
+func isVin(id string) bool {
+	return len(id) == vinLength
+}
+
+// decodeVin validates the VIN's characters and check digit and decodes its sections
+func decodeVin(vin string) (*VinInfo, error) {
+	vin = strings.ToUpper(vin)
+	if len(vin) != vinLength {
+		return nil, fmt.Errorf("VIN %s must be %d characters", vin, vinLength)
+	}
+
+	sum := 0
+	for i := 0; i < vinLength; i++ {
+		value, ok := vinValue(vin[i])
+		if !ok {
+			return nil, fmt.Errorf("VIN %s has invalid character %q at position %d", vin, vin[i], i+1)
+		}
+		sum += value * vinWeights[i]
+	}
+
+	check := strconv.Itoa(sum % 11)
+	if check == "10" {
+		check = "X"
+	}
+
+	if string(vin[8]) != check {
+		return nil, fmt.Errorf("VIN %s has check digit %c, expected %s", vin, vin[8], check)
+	}
+
+	yearIndex := strings.IndexByte(vinYearCodes, vin[9])
+	if yearIndex < 0 {
+		return nil, fmt.Errorf("VIN %s has invalid model year code %c", vin, vin[9])
+	}
+
+	// a letter in position 7 marks the 2010-2039 cycle
+	modelYear := 1980 + yearIndex
+	if vin[6] < '0' || vin[6] > '9' {
+		modelYear += 30
+	}
+
+	return &VinInfo{
+		Vin:          vin,
+		Wmi:          vin[0:3],
+		Vds:          vin[3:8],
+		CheckDigit:   check,
+		ModelYear:    modelYear,
+		PlantCode:    vin[10:11],
+		SerialNumber: vin[11:],
+	}, nil
+}
+
+// checkVin validates the VIN and cross-checks its world manufacturer identifier and model year against the car
+func checkVin(ctx contractapi.TransactionContextInterface, car *Car) error {
+	info, err := decodeVin(car.CarId)
+	if err != nil {
+		return err
+	}
+
+	registration := new(WmiRegistration)
+	found, err := getAsset(ctx, wmiType, []string{info.Wmi}, registration)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return fmt.Errorf("World manufacturer identifier %s is not registered", info.Wmi)
+	}
+
+	if registration.ManufacturerId != car.ManufacturerId {
+		return fmt.Errorf("VIN %s belongs to %s, not %s", info.Vin, registration.ManufacturerId, car.ManufacturerId)
+	}
+
+	if car.CarMake != strconv.Itoa(info.ModelYear) {
+		return fmt.Errorf("VIN %s encodes model year %d, not %s", info.Vin, info.ModelYear, car.CarMake)
+	}
+
+	car.CarId = info.Vin
+	car.Vin = info.Vin
+
+	return nil
+}
+
+// DecodeVin validates the VIN and returns its sections and model year
+func (s *CarChainCode) DecodeVin(ctx contractapi.TransactionContextInterface, vin string) (*VinInfo, error) {
+	return decodeVin(vin)
+}
+
+// normalizeWmi upper-cases a world manufacturer identifier and checks its length and characters
+func normalizeWmi(wmi string) (string, error) {
+	wmi = strings.ToUpper(wmi)
+	if len(wmi) != 3 {
+		return "", fmt.Errorf("World manufacturer identifier must be 3 characters, got %s", wmi)
+	}
+
+	for i := 0; i < len(wmi); i++ {
+		if _, ok := vinValue(wmi[i]); !ok {
+			return "", fmt.Errorf("World manufacturer identifier %s has invalid character %q", wmi, wmi[i])
+		}
+	}
+
+	return wmi, nil
+}
+
+// RegisterWmi assigns an unregistered world manufacturer identifier to an active manufacturer so its VINs can be created
+func (s *CarChainCode) RegisterWmi(ctx contractapi.TransactionContextInterface, wmi string, manufacturerId string) error {
+	if err := s.requireRole(ctx, roleAdmin); err != nil {
+		return err
+	}
+
+	wmi, err := normalizeWmi(wmi)
+	if err != nil {
+		return err
+	}
+
+	if err := requireActiveParticipant(ctx, roleManufacturer, manufacturerId); err != nil {
+		return err
+	}
+
+	registration := new(WmiRegistration)
+	found, err := getAsset(ctx, wmiType, []string{wmi}, registration)
+	if err != nil {
+		return err
+	}
+
+	if found {
+		return fmt.Errorf("World manufacturer identifier %s is already registered to %s", wmi, registration.ManufacturerId)
+	}
+
+	return putAsset(ctx, wmiType, []string{wmi}, WmiRegistration{DocType: wmiType, Wmi: wmi, ManufacturerId: manufacturerId})
+}
+
+// TransferWmi reassigns a registered world manufacturer identifier from its current manufacturer to another active one
+func (s *CarChainCode) TransferWmi(ctx contractapi.TransactionContextInterface, wmi string, fromManufacturerId string, toManufacturerId string) error {
+	if err := s.requireRole(ctx, roleAdmin); err != nil {
+		return err
+	}
+
+	wmi, err := normalizeWmi(wmi)
+	if err != nil {
+		return err
+	}
+
+	registration := new(WmiRegistration)
+	found, err := getAsset(ctx, wmiType, []string{wmi}, registration)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return fmt.Errorf("World manufacturer identifier %s is not registered", wmi)
+	}
+
+	if registration.ManufacturerId != fromManufacturerId {
+		return fmt.Errorf("World manufacturer identifier %s is registered to %s, not %s", wmi, registration.ManufacturerId, fromManufacturerId)
+	}
+
+	if err := requireActiveParticipant(ctx, roleManufacturer, toManufacturerId); err != nil {
+		return err
+	}
+
+	registration.ManufacturerId = toManufacturerId
+	return putAsset(ctx, wmiType, []string{wmi}, registration)
+}
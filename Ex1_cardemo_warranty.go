@@ -0,0 +1,354 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Composite key object types of warranty assets
+const (
+	warrantyPolicyType = "warrantyPolicy"
+	warrantyType       = "warranty"
+	warrantyClaimType  = "warrantyClaim"
+)
+
+// Warranty claim states
+const (
+	claimFiled    = "FILED"
+	claimApproved = "APPROVED"
+	claimRejected = "REJECTED"
+)
+
+// WarrantyPolicyInput is the input of SetWarrantyPolicy
+type WarrantyPolicyInput struct {
+	CarModel   string `json:"carModel"`
+	TermMonths int    `json:"termMonths"`
+	MaxMileage int    `json:"maxMileage"`
+}
+
+// WarrantyPolicy is the warranty a manufacturer gives every car of a model when it is first sold
+type WarrantyPolicy struct {
+	DocType        string `json:"docType"`
+	ManufacturerId string `json:"manufacturerId"`
+	CarModel       string `json:"carModel"`
+	TermMonths     int    `json:"termMonths"`
+	MaxMileage     int    `json:"maxMileage"`
+}
+
+// Warranty covers a car from its first sale until EndDate or until its odometer passes MaxMileage
+type Warranty struct {
+	DocType        string `json:"docType"`
+	CarId          string `json:"carId"`
+	ManufacturerId string `json:"manufacturerId"`
+	CarModel       string `json:"carModel"`
+	StartDate      string `json:"startDate"`
+	EndDate        string `json:"endDate"`
+	MaxMileage     int    `json:"maxMileage"`
+	TxId           string `json:"txId"`
+}
+
+// WarrantyClaimInput is the input of FileWarrantyClaim
+type WarrantyClaimInput struct {
+	CarId       string `json:"carId"`
+	Description string `json:"description"`
+	Odometer    int    `json:"odometer"`
+	Amount      int    `json:"amount"`
+}
+
+// WarrantyClaim is a dealer's claim against a car's warranty, decided by the car's manufacturer
+type WarrantyClaim struct {
+	DocType        string `json:"docType"`
+	ClaimId        string `json:"claimId"`
+	CarId          string `json:"carId"`
+	DealerId       string `json:"dealerId"`
+	ManufacturerId string `json:"manufacturerId"`
+	Description    string `json:"description"`
+	Odometer       int    `json:"odometer"`
+	Amount         int    `json:"amount"`
+	Status         string `json:"status"`
+	FiledOn        string `json:"filedOn"`
+	DecidedOn      string `json:"decidedOn"`
+	Reason         string `json:"reason"`
+}
+
+// startWarranty starts the warranty of a car on its first sale from its model's policy.
+// Resold cars keep the warranty they already have, and models without a policy get none.
+func startWarranty(ctx contractapi.TransactionContextInterface, car *Car) error {
+	existing := new(Warranty)
+	found, err := getAsset(ctx, warrantyType, []string{car.CarId}, existing)
+	if err != nil || found {
+		return err
+	}
+
+	policy := new(WarrantyPolicy)
+	found, err = getAsset(ctx, warrantyPolicyType, []string{car.ManufacturerId, car.CarModel}, policy)
+	if err != nil || !found {
+		return err
+	}
+
+	start, err := time.Parse(dateLayout, car.SoldOnDate)
+	if err != nil {
+		return fmt.Errorf("Car %s has an invalid sold date %q", car.CarId, car.SoldOnDate)
+	}
+
+	warranty := Warranty{
+		DocType:        warrantyType,
+		CarId:          car.CarId,
+		ManufacturerId: car.ManufacturerId,
+		CarModel:       car.CarModel,
+		StartDate:      car.SoldOnDate,
+		EndDate:        start.AddDate(0, policy.TermMonths, 0).Format(dateLayout),
+		MaxMileage:     policy.MaxMileage,
+		TxId:           ctx.GetStub().GetTxID(),
+	}
+
+	return putAsset(ctx, warrantyType, []string{warranty.CarId}, warranty)
+}
+
+// SetWarrantyPolicy sets the warranty the submitting manufacturer gives cars of a model sold from now on
+func (s *CarChainCode) SetWarrantyPolicy(ctx contractapi.TransactionContextInterface, input WarrantyPolicyInput) (*WarrantyPolicy, error) {
+	if err := s.requireRole(ctx, roleManufacturer); err != nil {
+		return nil, err
+	}
+
+	manufacturerId, err := registeredSubmitter(ctx, roleManufacturer)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := requireActiveParticipant(ctx, roleManufacturer, manufacturerId); err != nil {
+		return nil, err
+	}
+
+	if input.CarModel == "" {
+		return nil, fmt.Errorf("Car model must be set")
+	}
+
+	if input.TermMonths <= 0 || input.MaxMileage <= 0 {
+		return nil, fmt.Errorf("Warranty term and mileage must be positive")
+	}
+
+	policy := WarrantyPolicy{
+		DocType:        warrantyPolicyType,
+		ManufacturerId: manufacturerId,
+		CarModel:       input.CarModel,
+		TermMonths:     input.TermMonths,
+		MaxMileage:     input.MaxMileage,
+	}
+
+	if err := putAsset(ctx, warrantyPolicyType, []string{policy.ManufacturerId, policy.CarModel}, policy); err != nil {
+		return nil, err
+	}
+
+	return &policy, nil
+}
+
+// QueryWarrantyPolicy returns the manufacturer's warranty policy for a model
+func (s *CarChainCode) QueryWarrantyPolicy(ctx contractapi.TransactionContextInterface, manufacturerId string, carModel string) (*WarrantyPolicy, error) {
+	policy := new(WarrantyPolicy)
+	found, err := getAsset(ctx, warrantyPolicyType, []string{manufacturerId, carModel}, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, fmt.Errorf("%s has no warranty policy for %s", manufacturerId, carModel)
+	}
+
+	return policy, nil
+}
+
+// QueryWarranty returns the car's warranty
+func (s *CarChainCode) QueryWarranty(ctx contractapi.TransactionContextInterface, carId string) (*Warranty, error) {
+	warranty := new(Warranty)
+	found, err := getAsset(ctx, warrantyType, []string{carId}, warranty)
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, fmt.Errorf("%s has no warranty", carId)
+	}
+
+	return warranty, nil
+}
+
+// FileWarrantyClaim lets a dealer claim repairs on a sold car that are covered by its warranty's term and mileage.
+// The mileage is the higher of the claim's reading and the car's odometer, and the claim's reading is recorded like any other.
+func (s *CarChainCode) FileWarrantyClaim(ctx contractapi.TransactionContextInterface, input WarrantyClaimInput) (*WarrantyClaim, error) {
+	if err := s.requireRole(ctx, roleDealer); err != nil {
+		return nil, err
+	}
+
+	dealerId, err := registeredSubmitter(ctx, roleDealer)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := requireActiveParticipant(ctx, roleDealer, dealerId); err != nil {
+		return nil, err
+	}
+
+	if input.Description == "" {
+		return nil, fmt.Errorf("Warranty claim must be described")
+	}
+
+	if input.Odometer < 0 || input.Amount <= 0 {
+		return nil, fmt.Errorf("Odometer cannot be negative and the claimed amount must be positive")
+	}
+
+	car, err := s.QueryCar(ctx, input.CarId)
+	if err != nil {
+		return nil, err
+	}
+
+	if car.Status != statusSold && car.Status != statusTradedIn {
+		return nil, fmt.Errorf("Car %s is %s and can only be claimed on once sold", input.CarId, car.Status)
+	}
+
+	warranty, err := s.QueryWarranty(ctx, input.CarId)
+	if err != nil {
+		return nil, err
+	}
+
+	filedOn, err := txDate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if dateBefore(warranty.EndDate, filedOn) {
+		return nil, fmt.Errorf("Warranty of %s expired on %s", input.CarId, warranty.EndDate)
+	}
+
+	mileage := input.Odometer
+	if car.Odometer > mileage {
+		mileage = car.Odometer
+	}
+	if mileage > warranty.MaxMileage {
+		return nil, fmt.Errorf("Warranty of %s covers up to %d on the odometer, the car reads %d", input.CarId, warranty.MaxMileage, mileage)
+	}
+
+	reading, err := s.recordOdometer(ctx, car, input.Odometer, odometerSourceWarrantyClaim)
+	if err != nil {
+		return nil, err
+	}
+
+	claim := WarrantyClaim{
+		DocType:        warrantyClaimType,
+		ClaimId:        ctx.GetStub().GetTxID(),
+		CarId:          input.CarId,
+		DealerId:       dealerId,
+		ManufacturerId: warranty.ManufacturerId,
+		Description:    input.Description,
+		Odometer:       input.Odometer,
+		Amount:         input.Amount,
+		Status:         claimFiled,
+		FiledOn:        filedOn,
+	}
+
+	if err := putAsset(ctx, warrantyClaimType, []string{claim.CarId, claim.ClaimId}, claim); err != nil {
+		return nil, err
+	}
+
+	if err := s.putCarWithReading(ctx, car, reading); err != nil {
+		return nil, err
+	}
+
+	return &claim, nil
+}
+
+// decideClaim lets the manufacturer behind a filed claim approve or reject it
+func (s *CarChainCode) decideClaim(ctx contractapi.TransactionContextInterface, carId string, claimId string, status string, reason string) (*WarrantyClaim, error) {
+	if err := s.requireRole(ctx, roleManufacturer); err != nil {
+		return nil, err
+	}
+
+	manufacturerId, err := registeredSubmitter(ctx, roleManufacturer)
+	if err != nil {
+		return nil, err
+	}
+
+	claim := new(WarrantyClaim)
+	found, err := getAsset(ctx, warrantyClaimType, []string{carId, claimId}, claim)
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, fmt.Errorf("Car %s has no warranty claim %s", carId, claimId)
+	}
+
+	if claim.ManufacturerId != manufacturerId {
+		return nil, fmt.Errorf("Warranty claim %s is decided by %s, not %s", claimId, claim.ManufacturerId, manufacturerId)
+	}
+
+	if claim.Status != claimFiled {
+		return nil, fmt.Errorf("Warranty claim %s is already %s", claimId, claim.Status)
+	}
+
+	claim.Status = status
+	claim.Reason = reason
+	claim.DecidedOn, err = txDate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := putAsset(ctx, warrantyClaimType, []string{carId, claimId}, claim); err != nil {
+		return nil, err
+	}
+
+	return claim, nil
+}
+
+// ApproveWarrantyClaim lets the car's manufacturer accept a filed warranty claim
+func (s *CarChainCode) ApproveWarrantyClaim(ctx contractapi.TransactionContextInterface, carId string, claimId string) (*WarrantyClaim, error) {
+	return s.decideClaim(ctx, carId, claimId, claimApproved, "")
+}
+
+// RejectWarrantyClaim lets the car's manufacturer turn down a filed warranty claim, giving a reason
+func (s *CarChainCode) RejectWarrantyClaim(ctx contractapi.TransactionContextInterface, carId string, claimId string, reason string) (*WarrantyClaim, error) {
+	if reason == "" {
+		return nil, fmt.Errorf("Rejection reason must be given")
+	}
+
+	return s.decideClaim(ctx, carId, claimId, claimRejected, reason)
+}
+
+// QueryWarrantyClaims returns the car's warranty claims in the order they were filed
+func (s *CarChainCode) QueryWarrantyClaims(ctx contractapi.TransactionContextInterface, carId string) ([]WarrantyClaim, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(warrantyClaimType, []string{carId})
+
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	claims := []WarrantyClaim{}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		claim := WarrantyClaim{}
+		if err := json.Unmarshal(queryResponse.Value, &claim); err != nil {
+			return nil, fmt.Errorf("Failed to decode warranty claim %s. %s", queryResponse.Key, err.Error())
+		}
+		claims = append(claims, claim)
+	}
+
+	sort.SliceStable(claims, func(i, j int) bool {
+		return dateBefore(claims[i].FiledOn, claims[j].FiledOn)
+	})
+
+	return claims, nil
+}
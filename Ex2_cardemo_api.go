@@ -6,6 +6,8 @@ SPDX-License-Identifier: Apache-2.0
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -14,6 +16,8 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/hyperledger/fabric-sdk-go/pkg/core/config"
@@ -24,21 +28,93 @@ import (
  Car structure to to store the world state
 */
 type Car struct {
+	DocType                    string      `json:"docType"`
+	SchemaVersion              int         `json:"schemaVersion"`
+	ManufacturerId             string      `json:"manufacturerId"`
+	CarId                      string      `json:"carId"`
+	Vin                        string      `json:"vin,omitempty"`
+	DealerId                   string      `json:"dealerId"`
+	ConsumerId                 string      `json:"consumerId"`
+	CarMake                    string      `json:"carMake"`
+	CarModel                   string      `json:"carModel"`
+	CarColor                   string      `json:"carColor"`
+	Status                     string      `json:"status"`
+	ManufacturingDate          string      `json:"manufacturingDate"`
+	ShippingDate               string      `json:"shippingDate"`
+	DeliveryDate               string      `json:"deliveryDate"`
+	SoldOnDate                 string      `json:"soldOnDate"`
+	ShipmentId                 string      `json:"shipmentId,omitempty"`
+	CarrierId                  string      `json:"carrierId,omitempty"`
+	Owners                     []Ownership `json:"owners,omitempty"`
+	OpenRecalls                []string    `json:"openRecalls,omitempty"`
+	ScrappedOnDate             string      `json:"scrappedOnDate,omitempty"`
+	DestructionCertificateHash string      `json:"destructionCertificateHash,omitempty"`
+	Odometer                   int         `json:"odometer"`
+	OdometerRollback           bool        `json:"odometerRollback,omitempty"`
+	LienId                     string      `json:"lienId,omitempty"`
+	LienHolderId               string      `json:"lienHolderId,omitempty"`
+}
+
+// Ownership is one link in a car's chain of owners, mirroring the chaincode's Ownership
+type Ownership struct {
+	OwnerId   string `json:"ownerId"`
+	OwnerType string `json:"ownerType"`
+	Since     string `json:"since"`
+	TxId      string `json:"txId"`
+}
+
+// TransferRequest is the body of the transfer requests
+type TransferRequest struct {
+	CarId        string `json:"carId"`
+	NewOwnerId   string `json:"newOwnerId"`
+	NewOwnerType string `json:"newOwnerType"`
+	Odometer     *int   `json:"odometer"`
+}
+
+// CarPrices is passed to the chaincode in the transient map, mirroring the chaincode's CarPrices
+type CarPrices struct {
+	ManufacturerPrice int    `json:"manufacturerPrice"`
+	ShippingPrice     int    `json:"shippingPrice"`
+	CustomerPrice     int    `json:"customerPrice"`
+	Salt              string `json:"salt"`
+}
+
+// CarInput mirrors the chaincode's CarInput
+type CarInput struct {
 	ManufacturerId    string `json:"manufacturerId"`
 	CarId             string `json:"carId"`
-	DealerId          string `json:"dealerId"`
-	ConsumerId        string `json:"consumerId"`
 	CarMake           string `json:"carMake"`
 	CarModel          string `json:"carModel"`
 	CarColor          string `json:"carColor"`
-	Status            string `json:"status"`
 	ManufacturingDate string `json:"manufacturingDate"`
-	ShippingDate      string `json:"shippingDate"`
-	DeliveryDate      string `json:"deliveryDate"`
-	SoldOnDate        string `json:"soldOnDate"`
-	ManufacturerPrice int    `json:"manufacturerPrice"`
-	ShippingPrice     int    `json:"shippingPrice"`
-	CustomerPrice     int    `json:"customerPrice"`
+}
+
+// CarRequest is the body of the create, ship, receive and sell requests.
+// Odometer shadows the Car's so that ship, receive and sell can tell a missing reading from 0.
+type CarRequest struct {
+	Car
+	CarPrices
+	Odometer *int `json:"odometer"`
+}
+
+// requireOdometer answers 400 and returns false when a request leaves out its odometer reading,
+// which would otherwise reach the chaincode as 0
+func requireOdometer(w http.ResponseWriter, odometer *int) bool {
+	if odometer == nil {
+		http.Error(w, "odometer is required", http.StatusBadRequest)
+		return false
+	}
+
+	return true
+}
+
+// CarFilter selects cars by field value, mirroring the chaincode's CarFilter
+type CarFilter struct {
+	Status         string `json:"status,omitempty"`
+	DealerId       string `json:"dealerId,omitempty"`
+	ManufacturerId string `json:"manufacturerId,omitempty"`
+	ConsumerId     string `json:"consumerId,omitempty"`
+	CarModel       string `json:"carModel,omitempty"`
 }
 
 /* let's declare a global Car array
@@ -47,61 +123,108 @@ type Car struct {
 */
 var cars []Car
 
-func GetContract(w http.ResponseWriter) *gateway.Contract {
+// GetContract connects to the chaincode as the identity the request selects, see requestIdentity
+func GetContract(w http.ResponseWriter, r *http.Request) *gateway.Contract {
+	label, err := requestIdentity(r)
+	if err != nil {
+		fmt.Fprintf(w, "%s\n", err)
+	}
+
+	gw, err := newGateway(label)
+	if err != nil {
+		fmt.Fprintf(w, "%s\n", err)
+	}
+	defer gw.Close()
+
+	network, err := gw.GetNetwork("mychannel")
+	if err != nil {
+		fmt.Fprintf(w, "Failed to get network: %s\n", err)
+	}
+
+	contract := network.GetContract("cardemo")
+
+	return contract
+}
+
+// identityHeader is the request header naming the enrolled identity that submits the request, as <user>@<org domain>
+const identityHeader = "X-Cardemo-Identity"
+
+// identityEnv names the environment variable holding the identity used when a request names none
+const identityEnv = "CARDEMO_IDENTITY"
+
+// requestIdentity returns the wallet label of the identity the request is submitted as. The chaincode takes the
+// caller's role and participant id from that identity's certificate, so each caller must use its own enrolled identity.
+func requestIdentity(r *http.Request) (string, error) {
+	label := r.Header.Get(identityHeader)
+	if label == "" {
+		label = os.Getenv(identityEnv)
+	}
+
+	if _, _, err := splitIdentity(label); err != nil {
+		return "", err
+	}
+
+	return label, nil
+}
+
+// splitIdentity splits an identity label into the enrolled user and the domain of its organization
+func splitIdentity(label string) (string, string, error) {
+	parts := strings.Split(label, "@")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" || strings.ContainsAny(label, `/\`) {
+		return "", "", fmt.Errorf("Identity must be <user>@<org domain>, set by the %s header or %s, got %q", identityHeader, identityEnv, label)
+	}
+
+	return parts[0], parts[1], nil
+}
+
+// orgPath returns the path of the organization's directory in the test network
+func orgPath(orgDomain string, elem ...string) string {
+	return filepath.Join(append([]string{"..", "..", "test-network", "organizations", "peerOrganizations", orgDomain}, elem...)...)
+}
+
+// newGateway connects to the test network as the enrolled identity, populating the wallet on first use
+func newGateway(label string) (*gateway.Gateway, error) {
+	_, orgDomain, err := splitIdentity(label)
+	if err != nil {
+		return nil, err
+	}
+
 	os.Setenv("DISCOVERY_AS_LOCALHOST", "true")
 	wallet, err := gateway.NewFileSystemWallet("wallet")
 	if err != nil {
-		fmt.Fprintf(w, "Failed to create wallet: %s\n", err)
+		return nil, fmt.Errorf("Failed to create wallet: %s", err)
 	}
 
-	if !wallet.Exists("CarDemoappUser") {
-		err = populateWallet(wallet)
+	if !wallet.Exists(label) {
+		err = populateWallet(wallet, label)
 		if err != nil {
-			fmt.Fprintf(w, "Failed to populate CarDemoappUser wallet contents: %s\n", err)
+			return nil, fmt.Errorf("Failed to populate %s wallet contents: %s", label, err)
 		}
 	}
 
-	ccpPath := filepath.Join(
-		"..",
-		"..",
-		"test-network",
-		"organizations",
-		"peerOrganizations",
-		"org1.example.com",
-		"connection-org1.yaml",
-	)
+	org := strings.SplitN(orgDomain, ".", 2)[0]
+	ccpPath := orgPath(orgDomain, "connection-"+org+".yaml")
 
 	gw, err := gateway.Connect(
 		gateway.WithConfig(config.FromFile(filepath.Clean(ccpPath))),
-		gateway.WithIdentity(wallet, "CarDemoappUser"),
+		gateway.WithIdentity(wallet, label),
 	)
 	if err != nil {
-		fmt.Fprintf(w, "Failed to connect to gateway: %s\n", err)
+		return nil, fmt.Errorf("Failed to connect to gateway: %s", err)
 	}
-	defer gw.Close()
 
-	network, err := gw.GetNetwork("mychannel")
+	return gw, nil
+}
+
+// populateWallet adds the identity enrolled under the organization's users directory to the wallet.
+// Its MSP ID follows the test network's naming, org1.example.com belonging to Org1MSP.
+func populateWallet(wallet *gateway.Wallet, label string) error {
+	_, orgDomain, err := splitIdentity(label)
 	if err != nil {
-		fmt.Fprintf(w, "Failed to get network: %s\n", err)
+		return err
 	}
 
-	contract := network.GetContract("cardemo")
-
-	return contract
-}
-
-func populateWallet(wallet *gateway.Wallet) error {
-	credPath := filepath.Join(
-		"..",
-		"..",
-		"test-network",
-		"organizations",
-		"peerOrganizations",
-		"org1.example.com",
-		"users",
-		"User1@org1.example.com",
-		"msp",
-	)
+	credPath := orgPath(orgDomain, "users", label, "msp")
 
 	certPath := filepath.Join(credPath, "signcerts", "cert.pem")
 	// read the certificate pem
@@ -125,9 +248,11 @@ func populateWallet(wallet *gateway.Wallet) error {
 		return err
 	}
 
-	identity := gateway.NewX509Identity("Org1MSP", string(cert), string(key))
+	org := strings.SplitN(orgDomain, ".", 2)[0]
+	mspID := strings.ToUpper(org[:1]) + org[1:] + "MSP"
+	identity := gateway.NewX509Identity(mspID, string(cert), string(key))
 
-	err = wallet.Put("CarDemoappUser", identity)
+	err = wallet.Put(label, identity)
 	if err != nil {
 		return err
 	}
@@ -140,18 +265,82 @@ func welcome(w http.ResponseWriter, r *http.Request) {
 }
 
 func returnAllCars(w http.ResponseWriter, r *http.Request) {
-	contract := GetContract(w)
-	result, err := contract.EvaluateTransaction("QueryAllCars")
+	query := r.URL.Query()
+	filter := CarFilter{
+		Status:         query.Get("status"),
+		DealerId:       query.Get("dealerId"),
+		ManufacturerId: query.Get("manufacturerId"),
+		ConsumerId:     query.Get("consumerId"),
+		CarModel:       query.Get("carModel"),
+	}
+	if filter != (CarFilter{}) {
+		returnFilteredCars(w, r, filter)
+		return
+	}
+
+	limit := query.Get("limit")
+	cursor := query.Get("cursor")
+	includeScrapped := strconv.FormatBool(query.Get("includeScrapped") == "true")
+	if limit == "" && cursor != "" {
+		http.Error(w, "cursor requires limit", http.StatusBadRequest)
+		return
+	}
+
+	contract := GetContract(w, r)
+	if limit != "" {
+		// Call QueryAllCarsWithPagination Function and supply paramters like pageSize int32, bookmark string, includeScrapped bool
+		result, err := contract.EvaluateTransaction("QueryAllCarsWithPagination", limit, cursor, includeScrapped)
+		if err != nil {
+			fmt.Fprintf(w, "Failed to evaluate QueryAllCarsWithPagination transaction: %s\n", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(result)
+		return
+	}
+
+	result, err := contract.EvaluateTransaction("QueryAllCars", includeScrapped)
 	if err != nil {
 		fmt.Fprintf(w, "Failed to evaluate transaction: %s\n", err)
 	}
 	json.NewEncoder(w).Encode(result)
 }
 
+// returnFilteredCars answers /getCars filters with a CouchDB selector query, or with the
+// composite key indexes when called with statedb=leveldb
+func returnFilteredCars(w http.ResponseWriter, r *http.Request, filter CarFilter) {
+	if r.URL.Query().Get("limit") != "" || r.URL.Query().Get("cursor") != "" {
+		http.Error(w, "limit and cursor cannot be combined with filters", http.StatusBadRequest)
+		return
+	}
+
+	transaction := "QueryCarsBySelector"
+	switch r.URL.Query().Get("statedb") {
+	case "", "couchdb":
+	case "leveldb":
+		transaction = "QueryCarsByIndex"
+	default:
+		http.Error(w, "statedb must be couchdb or leveldb", http.StatusBadRequest)
+		return
+	}
+
+	filterAsBytes, _ := json.Marshal(filter)
+	contract := GetContract(w, r)
+
+	// Call the query Function and supply paramters like filter CarFilter
+	result, err := contract.EvaluateTransaction(transaction, string(filterAsBytes))
+	if err != nil {
+		fmt.Fprintf(w, "Failed to evaluate %s transaction: %s\n", transaction, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
+
 func returnSingleCar(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	key := vars["id"]
-	contract := GetContract(w)
+	contract := GetContract(w, r)
 
 	// Call QueryCar Function and by supplying CarID paramter
 	result, err := contract.EvaluateTransaction("QueryCar", key)
@@ -161,36 +350,145 @@ func returnSingleCar(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(result)
 }
 
+func returnCarHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key := vars["id"]
+	contract := GetContract(w, r)
+
+	// Call GetCarHistory Function and by supplying CarID paramter
+	result, err := contract.EvaluateTransaction("GetCarHistory", key)
+	if err != nil {
+		fmt.Fprintf(w, "Failed to evaluate GetCarHistory transaction: %s\n", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
+
+// submitWithPrices submits the transaction with the prices in its transient map, salting them when the client did not
+func submitWithPrices(contract *gateway.Contract, prices CarPrices, name string, args ...string) ([]byte, error) {
+	if prices.Salt == "" {
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, fmt.Errorf("Failed to generate salt: %s", err)
+		}
+		prices.Salt = hex.EncodeToString(salt)
+	}
+
+	pricesAsBytes, _ := json.Marshal(prices)
+	txn, err := contract.CreateTransaction(name, gateway.WithTransient(map[string][]byte{"prices": pricesAsBytes}))
+	if err != nil {
+		return nil, err
+	}
+
+	return txn.Submit(args...)
+}
+
+func returnManufacturerDealerPrice(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key := vars["id"]
+	contract := GetContract(w, r)
+
+	// Call QueryManufacturerDealerPrice Function and by supplying CarID paramter
+	result, err := contract.EvaluateTransaction("QueryManufacturerDealerPrice", key)
+	if err != nil {
+		fmt.Fprintf(w, "Failed to evaluate QueryManufacturerDealerPrice transaction: %s\n", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
+
+func returnDealerConsumerPrice(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key := vars["id"]
+	contract := GetContract(w, r)
+
+	// Call QueryDealerConsumerPrice Function and by supplying CarID paramter
+	result, err := contract.EvaluateTransaction("QueryDealerConsumerPrice", key)
+	if err != nil {
+		fmt.Fprintf(w, "Failed to evaluate QueryDealerConsumerPrice transaction: %s\n", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
+
 func _createNewCar(w http.ResponseWriter, r *http.Request) {
 	// get the body of the POST request
 	// unmarshal this into a new Car struct
 	// append this to our cars array.
 	reqBody, _ := ioutil.ReadAll(r.Body)
-	var newCar Car
+	var newCar CarRequest
 	json.Unmarshal(reqBody, &newCar)
 	// update our global cars array to include
 	// our new Car
-	cars = append(cars, newCar)
-	contract := GetContract(w)
-	result, err := contract.SubmitTransaction("createNewCar", newCar.ManufacturerId, newCar.CarId, newCar.CarMake, newCar.CarModel, newCar.CarColor, newCar.ManufacturingDate, string(newCar.ManufacturerPrice), "manufacturer")
+	cars = append(cars, newCar.Car)
+	contract := GetContract(w, r)
+	input, _ := json.Marshal(CarInput{ManufacturerId: newCar.ManufacturerId, CarId: newCar.CarId, CarMake: newCar.CarMake, CarModel: newCar.CarModel, CarColor: newCar.CarColor, ManufacturingDate: newCar.ManufacturingDate})
+	// Call CreateCar Function and supply paramters like input CarInput; manufacturerPrice goes in the transient map
+	result, err := submitWithPrices(contract, CarPrices{ManufacturerPrice: newCar.ManufacturerPrice, Salt: newCar.Salt}, "CreateCar", string(input))
 	if err != nil {
-		fmt.Fprintf(w, "Failed to submit  createNewCar transaction: %s\n", err)
+		fmt.Fprintf(w, "Failed to submit  CreateCar transaction: %s\n", err)
 	}
 	fmt.Fprintf(w, string(result))
 }
 
+func _createCarsBatch(w http.ResponseWriter, r *http.Request) {
+	// the body is a JSON array of CarRequest; prices go in the transient map, one per car
+	reqBody, _ := ioutil.ReadAll(r.Body)
+	var newCars []CarRequest
+	json.Unmarshal(reqBody, &newCars)
+
+	inputs := []CarInput{}
+	prices := []CarPrices{}
+	for _, newCar := range newCars {
+		inputs = append(inputs, CarInput{ManufacturerId: newCar.ManufacturerId, CarId: newCar.CarId, CarMake: newCar.CarMake, CarModel: newCar.CarModel, CarColor: newCar.CarColor, ManufacturingDate: newCar.ManufacturingDate})
+
+		price := CarPrices{ManufacturerPrice: newCar.ManufacturerPrice, Salt: newCar.Salt}
+		if price.Salt == "" {
+			salt := make([]byte, 16)
+			if _, err := rand.Read(salt); err != nil {
+				fmt.Fprintf(w, "Failed to generate salt: %s\n", err)
+				return
+			}
+			price.Salt = hex.EncodeToString(salt)
+		}
+		prices = append(prices, price)
+	}
+
+	inputsAsBytes, _ := json.Marshal(inputs)
+	pricesAsBytes, _ := json.Marshal(prices)
+	contract := GetContract(w, r)
+
+	// Call CreateCarsBatch Function and supply paramters like inputs []CarInput; the prices array goes in the transient map
+	txn, err := contract.CreateTransaction("CreateCarsBatch", gateway.WithTransient(map[string][]byte{"prices": pricesAsBytes}))
+	if err != nil {
+		fmt.Fprintf(w, "Failed to create  CreateCarsBatch transaction: %s\n", err)
+		return
+	}
+	result, err := txn.Submit(string(inputsAsBytes))
+	if err != nil {
+		fmt.Fprintf(w, "Failed to submit  CreateCarsBatch transaction: %s\n", err)
+	}
+	w.Write(result)
+}
+
 func _shipToDealer(w http.ResponseWriter, r *http.Request) {
 	// get the body of the POST request
 	// unmarshal this into a new Car struct
 	// append this to our cars array.
 	reqBody, _ := ioutil.ReadAll(r.Body)
-	var newCar Car
+	var newCar CarRequest
 	json.Unmarshal(reqBody, &newCar)
+	if !requireOdometer(w, newCar.Odometer) {
+		return
+	}
 	// update our global cars array to include
 	// our new Car
-	cars = append(cars, newCar)
-	contract := GetContract(w)
-	result, err := contract.SubmitTransaction("ShipToDealer", newCar.CarId, newCar.DealerId, string(newCar.ShippingPrice), "manufacturer")
+	cars = append(cars, newCar.Car)
+	contract := GetContract(w, r)
+	result, err := submitWithPrices(contract, CarPrices{ShippingPrice: newCar.ShippingPrice, Salt: newCar.Salt}, "ShipToDealer", newCar.CarId, newCar.DealerId, newCar.CarrierId, strconv.Itoa(*newCar.Odometer))
 	if err != nil {
 		fmt.Fprintf(w, "Failed to submit  createNewCar transaction: %s\n", err)
 	}
@@ -202,15 +500,18 @@ func _receiveDelivery(w http.ResponseWriter, r *http.Request) {
 	// unmarshal this into a new Car struct
 	// append this to our cars array.
 	reqBody, _ := ioutil.ReadAll(r.Body)
-	var newCar Car
+	var newCar CarRequest
 	json.Unmarshal(reqBody, &newCar)
+	if !requireOdometer(w, newCar.Odometer) {
+		return
+	}
 	// update our global cars array to include
 	// our new Car
-	cars = append(cars, newCar)
-	contract := GetContract(w)
+	cars = append(cars, newCar.Car)
+	contract := GetContract(w, r)
 
-	// Call ReceiveDelivery Function and supply paramters like carId string, role string
-	result, err := contract.SubmitTransaction("ReceiveDelivery", newCar.CarId, "dealer")
+	// Call ReceiveDelivery Function and supply paramters like carId string, odometer int
+	result, err := contract.SubmitTransaction("ReceiveDelivery", newCar.CarId, strconv.Itoa(*newCar.Odometer))
 	if err != nil {
 		fmt.Fprintf(w, "Failed to submit  ReceiveDelivery transaction: %s\n", err)
 	}
@@ -222,33 +523,819 @@ func _sellToCustomer(w http.ResponseWriter, r *http.Request) {
 	// unmarshal this into a new Car struct
 	// append this to our cars array.
 	reqBody, _ := ioutil.ReadAll(r.Body)
-	var newCar Car
+	var newCar CarRequest
 	json.Unmarshal(reqBody, &newCar)
+	if !requireOdometer(w, newCar.Odometer) {
+		return
+	}
 	// update our global cars array to include
 	// our new Car
-	cars = append(cars, newCar)
-	contract := GetContract(w)
+	cars = append(cars, newCar.Car)
+	contract := GetContract(w, r)
 
-	// Call SellToCustomer Function and supply paramters like carId string, consumerId string, customerPrice int, role string
-	result, err := contract.SubmitTransaction("SellToCustomer", newCar.CarId, newCar.ConsumerId, string(newCar.CustomerPrice), "dealer")
+	// Call SellToCustomer Function and supply paramters like carId string, consumerId string, odometer int; customerPrice goes in the transient map
+	result, err := submitWithPrices(contract, CarPrices{CustomerPrice: newCar.CustomerPrice, Salt: newCar.Salt}, "SellToCustomer", newCar.CarId, newCar.ConsumerId, strconv.Itoa(*newCar.Odometer))
 	if err != nil {
 		fmt.Fprintf(w, "Failed to submit  SellToCustomer transaction: %s\n", err)
 	}
 	fmt.Fprintf(w, string(result))
 }
 
+// IncidentRequest is the body of the reject, transit exception and return requests
+type IncidentRequest struct {
+	CarId       string          `json:"carId"`
+	Status      string          `json:"status"`
+	Description string          `json:"description"`
+	Inspection  json.RawMessage `json:"inspection"`
+	Odometer    *int            `json:"odometer"`
+}
+
+func _rejectDelivery(w http.ResponseWriter, r *http.Request) {
+	reqBody, _ := ioutil.ReadAll(r.Body)
+	var incident IncidentRequest
+	json.Unmarshal(reqBody, &incident)
+	if !requireOdometer(w, incident.Odometer) {
+		return
+	}
+	contract := GetContract(w, r)
+
+	// Call RejectDelivery Function and supply paramters like carId string, report InspectionReport, odometer int
+	result, err := contract.SubmitTransaction("RejectDelivery", incident.CarId, string(incident.Inspection), strconv.Itoa(*incident.Odometer))
+	if err != nil {
+		fmt.Fprintf(w, "Failed to submit  RejectDelivery transaction: %s\n", err)
+	}
+	w.Write(result)
+}
+
+func _reportTransitException(w http.ResponseWriter, r *http.Request) {
+	reqBody, _ := ioutil.ReadAll(r.Body)
+	var incident IncidentRequest
+	json.Unmarshal(reqBody, &incident)
+	contract := GetContract(w, r)
+
+	// Call ReportTransitException Function and supply paramters like carId string, status string, description string
+	result, err := contract.SubmitTransaction("ReportTransitException", incident.CarId, incident.Status, incident.Description)
+	if err != nil {
+		fmt.Fprintf(w, "Failed to submit  ReportTransitException transaction: %s\n", err)
+	}
+	w.Write(result)
+}
+
+func _returnToManufacturer(w http.ResponseWriter, r *http.Request) {
+	reqBody, _ := ioutil.ReadAll(r.Body)
+	var incident IncidentRequest
+	json.Unmarshal(reqBody, &incident)
+	if !requireOdometer(w, incident.Odometer) {
+		return
+	}
+	contract := GetContract(w, r)
+
+	// Call ReturnToManufacturer Function and supply paramters like carId string, reason string, odometer int
+	result, err := contract.SubmitTransaction("ReturnToManufacturer", incident.CarId, incident.Description, strconv.Itoa(*incident.Odometer))
+	if err != nil {
+		fmt.Fprintf(w, "Failed to submit  ReturnToManufacturer transaction: %s\n", err)
+	}
+	w.Write(result)
+}
+
+// ScrapRequest is the body of the scrap request
+type ScrapRequest struct {
+	CarId           string `json:"carId"`
+	CertificateHash string `json:"certificateHash"`
+	Odometer        *int   `json:"odometer"`
+}
+
+func _scrapCar(w http.ResponseWriter, r *http.Request) {
+	reqBody, _ := ioutil.ReadAll(r.Body)
+	var scrap ScrapRequest
+	json.Unmarshal(reqBody, &scrap)
+	if !requireOdometer(w, scrap.Odometer) {
+		return
+	}
+	contract := GetContract(w, r)
+
+	// Call ScrapCar Function and supply paramters like carId string, certificateHash string, odometer int
+	result, err := contract.SubmitTransaction("ScrapCar", scrap.CarId, scrap.CertificateHash, strconv.Itoa(*scrap.Odometer))
+	if err != nil {
+		fmt.Fprintf(w, "Failed to submit  ScrapCar transaction: %s\n", err)
+	}
+	w.Write(result)
+}
+
+func returnDeliveryIncidents(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key := vars["id"]
+	contract := GetContract(w, r)
+
+	// Call QueryDeliveryIncidents Function and by supplying CarID paramter
+	result, err := contract.EvaluateTransaction("QueryDeliveryIncidents", key)
+	if err != nil {
+		fmt.Fprintf(w, "Failed to evaluate QueryDeliveryIncidents transaction: %s\n", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
+
+func returnTracking(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key := vars["id"]
+	contract := GetContract(w, r)
+
+	// Call QueryTracking Function and by supplying CarID paramter
+	result, err := contract.EvaluateTransaction("QueryTracking", key)
+	if err != nil {
+		fmt.Fprintf(w, "Failed to evaluate QueryTracking transaction: %s\n", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
+
+func _recordCheckpoint(w http.ResponseWriter, r *http.Request) {
+	// the body is passed through as the chaincode's CheckpointInput
+	reqBody, _ := ioutil.ReadAll(r.Body)
+	contract := GetContract(w, r)
+
+	// Call RecordCheckpoint Function and supply paramters like input CheckpointInput
+	result, err := contract.SubmitTransaction("RecordCheckpoint", string(reqBody))
+	if err != nil {
+		fmt.Fprintf(w, "Failed to submit  RecordCheckpoint transaction: %s\n", err)
+	}
+	w.Write(result)
+}
+
+// TokenRequest is the body of the mint, transfer and approve requests.
+// CounterpartyType is only read by mint, to pick the collection holding the account.
+type TokenRequest struct {
+	AccountType string `json:"accountType"`
+	AccountId   string `json:"accountId"`
+	Amount      int    `json:"amount"`
+}
+
+func returnBalance(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	contract := GetContract(w, r)
+
+	// Call BalanceOf Function and by supplying participant type and ID paramters
+	result, err := contract.EvaluateTransaction("BalanceOf", vars["type"], vars["id"])
+	if err != nil {
+		fmt.Fprintf(w, "Failed to evaluate BalanceOf transaction: %s\n", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
+
+// submitWithSalt submits the token transaction with a fresh salt for the private records it creates in its transient map
+func submitWithSalt(contract *gateway.Contract, name string, args ...string) ([]byte, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("Failed to generate salt: %s", err)
+	}
+
+	txn, err := contract.CreateTransaction(name, gateway.WithTransient(map[string][]byte{"salt": []byte(hex.EncodeToString(salt))}))
+	if err != nil {
+		return nil, err
+	}
+
+	return txn.Submit(args...)
+}
+
+// submitTokenRequest submits a token transaction taking an account type, an account id and an amount
+func submitTokenRequest(w http.ResponseWriter, r *http.Request, name string) {
+	reqBody, _ := ioutil.ReadAll(r.Body)
+	var request TokenRequest
+	json.Unmarshal(reqBody, &request)
+	contract := GetContract(w, r)
+
+	result, err := submitWithSalt(contract, name, request.AccountType, request.AccountId, strconv.Itoa(request.Amount))
+	if err != nil {
+		fmt.Fprintf(w, "Failed to submit  %s transaction: %s\n", name, err)
+	}
+	w.Write(result)
+}
+
+func _mintTokens(w http.ResponseWriter, r *http.Request) {
+	// Call Mint Function and supply paramters like ownerType string, ownerId string, amount int
+	submitTokenRequest(w, r, "Mint")
+}
+
+func _transferTokens(w http.ResponseWriter, r *http.Request) {
+	// Call Transfer Function and supply paramters like toType string, toId string, amount int
+	submitTokenRequest(w, r, "Transfer")
+}
+
+func _approveTokens(w http.ResponseWriter, r *http.Request) {
+	// Call Approve Function and supply paramters like spenderType string, spenderId string, amount int
+	submitTokenRequest(w, r, "Approve")
+}
+
+// returnSalesReport evaluates a sales report transaction over the from and to query parameters
+func returnSalesReport(w http.ResponseWriter, r *http.Request, name string) {
+	query := r.URL.Query()
+	contract := GetContract(w, r)
+
+	result, err := contract.EvaluateTransaction(name, query.Get("from"), query.Get("to"))
+	if err != nil {
+		fmt.Fprintf(w, "Failed to evaluate %s transaction: %s\n", name, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
+
+func returnSalesByManufacturer(w http.ResponseWriter, r *http.Request) {
+	// Call QuerySalesByManufacturer Function and by supplying from and to paramters
+	returnSalesReport(w, r, "QuerySalesByManufacturer")
+}
+
+func returnSalesByDealer(w http.ResponseWriter, r *http.Request) {
+	// Call QuerySalesByDealer Function and by supplying from and to paramters
+	returnSalesReport(w, r, "QuerySalesByDealer")
+}
+
+func returnSalesByModel(w http.ResponseWriter, r *http.Request) {
+	// Call QuerySalesByModel Function and by supplying from and to paramters
+	returnSalesReport(w, r, "QuerySalesByModel")
+}
+
+func returnCarOwners(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key := vars["id"]
+	contract := GetContract(w, r)
+
+	// Call QueryCarOwners Function and by supplying CarID paramter
+	result, err := contract.EvaluateTransaction("QueryCarOwners", key)
+	if err != nil {
+		fmt.Fprintf(w, "Failed to evaluate QueryCarOwners transaction: %s\n", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
+
+func _offerTransfer(w http.ResponseWriter, r *http.Request) {
+	reqBody, _ := ioutil.ReadAll(r.Body)
+	var transfer TransferRequest
+	json.Unmarshal(reqBody, &transfer)
+	contract := GetContract(w, r)
+
+	// Call OfferTransfer Function and supply paramters like carId string, newOwnerId string, newOwnerType string
+	result, err := contract.SubmitTransaction("OfferTransfer", transfer.CarId, transfer.NewOwnerId, transfer.NewOwnerType)
+	if err != nil {
+		fmt.Fprintf(w, "Failed to submit  OfferTransfer transaction: %s\n", err)
+	}
+	w.Write(result)
+}
+
+func _acceptTransfer(w http.ResponseWriter, r *http.Request) {
+	reqBody, _ := ioutil.ReadAll(r.Body)
+	var transfer TransferRequest
+	json.Unmarshal(reqBody, &transfer)
+	if !requireOdometer(w, transfer.Odometer) {
+		return
+	}
+	contract := GetContract(w, r)
+
+	// Call AcceptTransfer Function and supply paramters like carId string, odometer int
+	result, err := contract.SubmitTransaction("AcceptTransfer", transfer.CarId, strconv.Itoa(*transfer.Odometer))
+	if err != nil {
+		fmt.Fprintf(w, "Failed to submit  AcceptTransfer transaction: %s\n", err)
+	}
+	w.Write(result)
+}
+
+func _cancelTransfer(w http.ResponseWriter, r *http.Request) {
+	reqBody, _ := ioutil.ReadAll(r.Body)
+	var transfer TransferRequest
+	json.Unmarshal(reqBody, &transfer)
+	contract := GetContract(w, r)
+
+	// Call CancelTransfer Function and supply paramters like carId string
+	result, err := contract.SubmitTransaction("CancelTransfer", transfer.CarId)
+	if err != nil {
+		fmt.Fprintf(w, "Failed to submit  CancelTransfer transaction: %s\n", err)
+	}
+	w.Write(result)
+}
+
+// RecallRequest is the body of the recall acknowledge, remedy and close requests
+type RecallRequest struct {
+	RecallId string `json:"recallId"`
+	CarId    string `json:"carId"`
+	Remedy   string `json:"remedy"`
+}
+
+func returnRecall(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key := vars["id"]
+	contract := GetContract(w, r)
+
+	// Call QueryRecall Function and by supplying RecallID paramter
+	result, err := contract.EvaluateTransaction("QueryRecall", key)
+	if err != nil {
+		fmt.Fprintf(w, "Failed to evaluate QueryRecall transaction: %s\n", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
+
+func returnOpenRecalls(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	dealerId := vars["dealerId"]
+	contract := GetContract(w, r)
+
+	// Call QueryOpenRecallsByDealer Function and by supplying DealerID paramter
+	result, err := contract.EvaluateTransaction("QueryOpenRecallsByDealer", dealerId)
+	if err != nil {
+		fmt.Fprintf(w, "Failed to evaluate QueryOpenRecallsByDealer transaction: %s\n", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
+
+func _createRecall(w http.ResponseWriter, r *http.Request) {
+	// the body is passed through as the chaincode's RecallInput
+	reqBody, _ := ioutil.ReadAll(r.Body)
+	contract := GetContract(w, r)
+
+	// Call CreateRecall Function and supply paramters like input RecallInput
+	result, err := contract.SubmitTransaction("CreateRecall", string(reqBody))
+	if err != nil {
+		fmt.Fprintf(w, "Failed to submit  CreateRecall transaction: %s\n", err)
+	}
+	w.Write(result)
+}
+
+func _acknowledgeRecall(w http.ResponseWriter, r *http.Request) {
+	reqBody, _ := ioutil.ReadAll(r.Body)
+	var recall RecallRequest
+	json.Unmarshal(reqBody, &recall)
+	contract := GetContract(w, r)
+
+	// Call AcknowledgeRecall Function and supply paramters like recallId string, carId string
+	result, err := contract.SubmitTransaction("AcknowledgeRecall", recall.RecallId, recall.CarId)
+	if err != nil {
+		fmt.Fprintf(w, "Failed to submit  AcknowledgeRecall transaction: %s\n", err)
+	}
+	w.Write(result)
+}
+
+func _remedyRecall(w http.ResponseWriter, r *http.Request) {
+	reqBody, _ := ioutil.ReadAll(r.Body)
+	var recall RecallRequest
+	json.Unmarshal(reqBody, &recall)
+	contract := GetContract(w, r)
+
+	// Call RecordRecallRemedy Function and supply paramters like recallId string, carId string, remedy string
+	result, err := contract.SubmitTransaction("RecordRecallRemedy", recall.RecallId, recall.CarId, recall.Remedy)
+	if err != nil {
+		fmt.Fprintf(w, "Failed to submit  RecordRecallRemedy transaction: %s\n", err)
+	}
+	w.Write(result)
+}
+
+func _closeRecall(w http.ResponseWriter, r *http.Request) {
+	reqBody, _ := ioutil.ReadAll(r.Body)
+	var recall RecallRequest
+	json.Unmarshal(reqBody, &recall)
+	contract := GetContract(w, r)
+
+	// Call CloseRecall Function and supply paramters like recallId string
+	result, err := contract.SubmitTransaction("CloseRecall", recall.RecallId)
+	if err != nil {
+		fmt.Fprintf(w, "Failed to submit  CloseRecall transaction: %s\n", err)
+	}
+	w.Write(result)
+}
+
+func returnServiceRecords(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key := vars["id"]
+	contract := GetContract(w, r)
+
+	// Call QueryServiceRecords Function and by supplying CarID paramter
+	result, err := contract.EvaluateTransaction("QueryServiceRecords", key)
+	if err != nil {
+		fmt.Fprintf(w, "Failed to evaluate QueryServiceRecords transaction: %s\n", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
+
+func _addServiceRecord(w http.ResponseWriter, r *http.Request) {
+	// the body is passed through as the chaincode's ServiceRecordInput
+	reqBody, _ := ioutil.ReadAll(r.Body)
+	contract := GetContract(w, r)
+
+	// Call AddServiceRecord Function and supply paramters like input ServiceRecordInput
+	result, err := contract.SubmitTransaction("AddServiceRecord", string(reqBody))
+	if err != nil {
+		fmt.Fprintf(w, "Failed to submit  AddServiceRecord transaction: %s\n", err)
+	}
+	w.Write(result)
+}
+
+func returnWarrantyPolicy(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	contract := GetContract(w, r)
+
+	// Call QueryWarrantyPolicy Function and supply paramters like manufacturerId string, carModel string
+	result, err := contract.EvaluateTransaction("QueryWarrantyPolicy", vars["manufacturerId"], vars["carModel"])
+	if err != nil {
+		fmt.Fprintf(w, "Failed to evaluate QueryWarrantyPolicy transaction: %s\n", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
+
+func _setWarrantyPolicy(w http.ResponseWriter, r *http.Request) {
+	// the body is passed through as the chaincode's WarrantyPolicyInput
+	reqBody, _ := ioutil.ReadAll(r.Body)
+	contract := GetContract(w, r)
+
+	// Call SetWarrantyPolicy Function and supply paramters like input WarrantyPolicyInput
+	result, err := contract.SubmitTransaction("SetWarrantyPolicy", string(reqBody))
+	if err != nil {
+		fmt.Fprintf(w, "Failed to submit  SetWarrantyPolicy transaction: %s\n", err)
+	}
+	w.Write(result)
+}
+
+func returnWarranty(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key := vars["id"]
+	contract := GetContract(w, r)
+
+	// Call QueryWarranty Function and by supplying CarID paramter
+	result, err := contract.EvaluateTransaction("QueryWarranty", key)
+	if err != nil {
+		fmt.Fprintf(w, "Failed to evaluate QueryWarranty transaction: %s\n", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
+
+func returnWarrantyClaims(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key := vars["id"]
+	contract := GetContract(w, r)
+
+	// Call QueryWarrantyClaims Function and by supplying CarID paramter
+	result, err := contract.EvaluateTransaction("QueryWarrantyClaims", key)
+	if err != nil {
+		fmt.Fprintf(w, "Failed to evaluate QueryWarrantyClaims transaction: %s\n", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
+
+func _fileWarrantyClaim(w http.ResponseWriter, r *http.Request) {
+	// the body is passed through as the chaincode's WarrantyClaimInput
+	reqBody, _ := ioutil.ReadAll(r.Body)
+	contract := GetContract(w, r)
+
+	// Call FileWarrantyClaim Function and supply paramters like input WarrantyClaimInput
+	result, err := contract.SubmitTransaction("FileWarrantyClaim", string(reqBody))
+	if err != nil {
+		fmt.Fprintf(w, "Failed to submit  FileWarrantyClaim transaction: %s\n", err)
+	}
+	w.Write(result)
+}
+
+// ClaimDecisionRequest is the body of the warranty claim approve and reject requests
+type ClaimDecisionRequest struct {
+	CarId   string `json:"carId"`
+	ClaimId string `json:"claimId"`
+	Reason  string `json:"reason"`
+}
+
+func _approveWarrantyClaim(w http.ResponseWriter, r *http.Request) {
+	reqBody, _ := ioutil.ReadAll(r.Body)
+	var decision ClaimDecisionRequest
+	json.Unmarshal(reqBody, &decision)
+	contract := GetContract(w, r)
+
+	// Call ApproveWarrantyClaim Function and supply paramters like carId string, claimId string
+	result, err := contract.SubmitTransaction("ApproveWarrantyClaim", decision.CarId, decision.ClaimId)
+	if err != nil {
+		fmt.Fprintf(w, "Failed to submit  ApproveWarrantyClaim transaction: %s\n", err)
+	}
+	w.Write(result)
+}
+
+func _rejectWarrantyClaim(w http.ResponseWriter, r *http.Request) {
+	reqBody, _ := ioutil.ReadAll(r.Body)
+	var decision ClaimDecisionRequest
+	json.Unmarshal(reqBody, &decision)
+	contract := GetContract(w, r)
+
+	// Call RejectWarrantyClaim Function and supply paramters like carId string, claimId string, reason string
+	result, err := contract.SubmitTransaction("RejectWarrantyClaim", decision.CarId, decision.ClaimId, decision.Reason)
+	if err != nil {
+		fmt.Fprintf(w, "Failed to submit  RejectWarrantyClaim transaction: %s\n", err)
+	}
+	w.Write(result)
+}
+
+// OdometerRequest is the body of the odometer request
+type OdometerRequest struct {
+	CarId   string `json:"carId"`
+	Reading *int   `json:"reading"`
+}
+
+func _recordOdometer(w http.ResponseWriter, r *http.Request) {
+	reqBody, _ := ioutil.ReadAll(r.Body)
+	var odometer OdometerRequest
+	json.Unmarshal(reqBody, &odometer)
+	if !requireOdometer(w, odometer.Reading) {
+		return
+	}
+	contract := GetContract(w, r)
+
+	// Call RecordOdometer Function and supply paramters like carId string, reading int
+	result, err := contract.SubmitTransaction("RecordOdometer", odometer.CarId, strconv.Itoa(*odometer.Reading))
+	if err != nil {
+		fmt.Fprintf(w, "Failed to submit  RecordOdometer transaction: %s\n", err)
+	}
+	w.Write(result)
+}
+
+func returnOdometerReadings(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key := vars["id"]
+	contract := GetContract(w, r)
+
+	// Call QueryOdometerReadings Function and by supplying CarID paramter
+	result, err := contract.EvaluateTransaction("QueryOdometerReadings", key)
+	if err != nil {
+		fmt.Fprintf(w, "Failed to evaluate QueryOdometerReadings transaction: %s\n", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
+
+func returnOdometerFlaggedCars(w http.ResponseWriter, r *http.Request) {
+	contract := GetContract(w, r)
+
+	// Call QueryOdometerFlaggedCars Function
+	result, err := contract.EvaluateTransaction("QueryOdometerFlaggedCars")
+	if err != nil {
+		fmt.Fprintf(w, "Failed to evaluate QueryOdometerFlaggedCars transaction: %s\n", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
+
+// LienRequest is the body of the lien requests
+type LienRequest struct {
+	CarId  string `json:"carId"`
+	LienId string `json:"lienId"`
+	Amount int    `json:"amount"`
+}
+
+func returnLiens(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key := vars["id"]
+	contract := GetContract(w, r)
+
+	// Call QueryLiens Function and by supplying CarID paramter
+	result, err := contract.EvaluateTransaction("QueryLiens", key)
+	if err != nil {
+		fmt.Fprintf(w, "Failed to evaluate QueryLiens transaction: %s\n", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
+
+func _offerLien(w http.ResponseWriter, r *http.Request) {
+	reqBody, _ := ioutil.ReadAll(r.Body)
+	var lien LienRequest
+	json.Unmarshal(reqBody, &lien)
+	contract := GetContract(w, r)
+
+	// Call OfferLien Function and supply paramters like carId string, amount int
+	result, err := contract.SubmitTransaction("OfferLien", lien.CarId, strconv.Itoa(lien.Amount))
+	if err != nil {
+		fmt.Fprintf(w, "Failed to submit  OfferLien transaction: %s\n", err)
+	}
+	w.Write(result)
+}
+
+func _acceptLien(w http.ResponseWriter, r *http.Request) {
+	reqBody, _ := ioutil.ReadAll(r.Body)
+	var lien LienRequest
+	json.Unmarshal(reqBody, &lien)
+	contract := GetContract(w, r)
+
+	// Call AcceptLien Function and supply paramters like carId string, lienId string
+	result, err := contract.SubmitTransaction("AcceptLien", lien.CarId, lien.LienId)
+	if err != nil {
+		fmt.Fprintf(w, "Failed to submit  AcceptLien transaction: %s\n", err)
+	}
+	w.Write(result)
+}
+
+func _declineLien(w http.ResponseWriter, r *http.Request) {
+	reqBody, _ := ioutil.ReadAll(r.Body)
+	var lien LienRequest
+	json.Unmarshal(reqBody, &lien)
+	contract := GetContract(w, r)
+
+	// Call DeclineLien Function and supply paramters like carId string, lienId string
+	result, err := contract.SubmitTransaction("DeclineLien", lien.CarId, lien.LienId)
+	if err != nil {
+		fmt.Fprintf(w, "Failed to submit  DeclineLien transaction: %s\n", err)
+	}
+	w.Write(result)
+}
+
+func _releaseLien(w http.ResponseWriter, r *http.Request) {
+	reqBody, _ := ioutil.ReadAll(r.Body)
+	var lien LienRequest
+	json.Unmarshal(reqBody, &lien)
+	contract := GetContract(w, r)
+
+	// Call ReleaseLien Function and supply paramters like carId string
+	result, err := contract.SubmitTransaction("ReleaseLien", lien.CarId)
+	if err != nil {
+		fmt.Fprintf(w, "Failed to submit  ReleaseLien transaction: %s\n", err)
+	}
+	w.Write(result)
+}
+
+func returnDecodedVin(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	vin := vars["vin"]
+	contract := GetContract(w, r)
+
+	// Call DecodeVin Function and by supplying VIN paramter
+	result, err := contract.EvaluateTransaction("DecodeVin", vin)
+	if err != nil {
+		fmt.Fprintf(w, "Failed to evaluate DecodeVin transaction: %s\n", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
+
+// ParticipantRequest mirrors the chaincode's ParticipantInput
+type ParticipantRequest struct {
+	ParticipantType string `json:"participantType"`
+	ParticipantId   string `json:"participantId"`
+	Name            string `json:"name"`
+	Location        string `json:"location"`
+	MSPID           string `json:"mspId"`
+}
+
+func returnParticipants(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	participantType := vars["type"]
+	contract := GetContract(w, r)
+
+	// Call QueryParticipants Function and by supplying participant type paramter
+	result, err := contract.EvaluateTransaction("QueryParticipants", participantType)
+	if err != nil {
+		fmt.Fprintf(w, "Failed to evaluate QueryParticipants transaction: %s\n", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
+
+func returnParticipant(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	contract := GetContract(w, r)
+
+	// Call QueryParticipant Function and by supplying participant type and ID paramters
+	result, err := contract.EvaluateTransaction("QueryParticipant", vars["type"], vars["id"])
+	if err != nil {
+		fmt.Fprintf(w, "Failed to evaluate QueryParticipant transaction: %s\n", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
+
+func _registerParticipant(w http.ResponseWriter, r *http.Request) {
+	// the body is passed through as the chaincode's ParticipantInput
+	reqBody, _ := ioutil.ReadAll(r.Body)
+	contract := GetContract(w, r)
+
+	// Call RegisterParticipant Function and supply paramters like input ParticipantInput
+	result, err := contract.SubmitTransaction("RegisterParticipant", string(reqBody))
+	if err != nil {
+		fmt.Fprintf(w, "Failed to submit  RegisterParticipant transaction: %s\n", err)
+	}
+	w.Write(result)
+}
+
+func _updateParticipant(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	reqBody, _ := ioutil.ReadAll(r.Body)
+	var participant ParticipantRequest
+	json.Unmarshal(reqBody, &participant)
+	participant.ParticipantType = vars["type"]
+	participant.ParticipantId = vars["id"]
+	input, _ := json.Marshal(participant)
+	contract := GetContract(w, r)
+
+	// Call UpdateParticipant Function and supply paramters like input ParticipantInput
+	result, err := contract.SubmitTransaction("UpdateParticipant", string(input))
+	if err != nil {
+		fmt.Fprintf(w, "Failed to submit  UpdateParticipant transaction: %s\n", err)
+	}
+	w.Write(result)
+}
+
+func _deactivateParticipant(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	contract := GetContract(w, r)
+
+	// Call DeactivateParticipant Function and supply paramters like participantType string, participantId string
+	result, err := contract.SubmitTransaction("DeactivateParticipant", vars["type"], vars["id"])
+	if err != nil {
+		fmt.Fprintf(w, "Failed to submit  DeactivateParticipant transaction: %s\n", err)
+	}
+	w.Write(result)
+}
+
 func handleRequests() {
 	myRouter := mux.NewRouter().StrictSlash(true)
 	myRouter.HandleFunc("/", welcome)
 	myRouter.HandleFunc("/getCars", returnAllCars)
 	myRouter.HandleFunc("/getCar/{id}", returnSingleCar)
+	myRouter.HandleFunc("/getCarHistory/{id}", returnCarHistory)
+	myRouter.HandleFunc("/getManufacturerDealerPrice/{id}", returnManufacturerDealerPrice)
+	myRouter.HandleFunc("/getDealerConsumerPrice/{id}", returnDealerConsumerPrice)
 	myRouter.HandleFunc("/create", _createNewCar).Methods("POST")
+	myRouter.HandleFunc("/create/batch", _createCarsBatch).Methods("POST")
 	myRouter.HandleFunc("/ship", _shipToDealer).Methods("POST")
 	myRouter.HandleFunc("/receive", _receiveDelivery).Methods("POST")
 	myRouter.HandleFunc("/sell", _sellToCustomer).Methods("POST")
+	myRouter.HandleFunc("/reject", _rejectDelivery).Methods("POST")
+	myRouter.HandleFunc("/transitException", _reportTransitException).Methods("POST")
+	myRouter.HandleFunc("/return", _returnToManufacturer).Methods("POST")
+	myRouter.HandleFunc("/getDeliveryIncidents/{id}", returnDeliveryIncidents)
+	myRouter.HandleFunc("/scrap", _scrapCar).Methods("POST")
+	myRouter.HandleFunc("/tracking/{id}", returnTracking)
+	myRouter.HandleFunc("/tracking", _recordCheckpoint).Methods("POST")
+	myRouter.HandleFunc("/balance/{type}/{id}", returnBalance)
+	myRouter.HandleFunc("/token/mint", _mintTokens).Methods("POST")
+	myRouter.HandleFunc("/token/transfer", _transferTokens).Methods("POST")
+	myRouter.HandleFunc("/token/approve", _approveTokens).Methods("POST")
+	myRouter.HandleFunc("/reports/manufacturers", returnSalesByManufacturer)
+	myRouter.HandleFunc("/reports/dealers", returnSalesByDealer)
+	myRouter.HandleFunc("/reports/models", returnSalesByModel)
+	myRouter.HandleFunc("/getCarOwners/{id}", returnCarOwners)
+	myRouter.HandleFunc("/transfer/offer", _offerTransfer).Methods("POST")
+	myRouter.HandleFunc("/transfer/accept", _acceptTransfer).Methods("POST")
+	myRouter.HandleFunc("/transfer/cancel", _cancelTransfer).Methods("POST")
+	myRouter.HandleFunc("/getRecall/{id}", returnRecall)
+	myRouter.HandleFunc("/getOpenRecalls/{dealerId}", returnOpenRecalls)
+	myRouter.HandleFunc("/recall", _createRecall).Methods("POST")
+	myRouter.HandleFunc("/recall/acknowledge", _acknowledgeRecall).Methods("POST")
+	myRouter.HandleFunc("/recall/remedy", _remedyRecall).Methods("POST")
+	myRouter.HandleFunc("/recall/close", _closeRecall).Methods("POST")
+	myRouter.HandleFunc("/getServiceRecords/{id}", returnServiceRecords)
+	myRouter.HandleFunc("/service", _addServiceRecord).Methods("POST")
+	myRouter.HandleFunc("/getOdometerReadings/{id}", returnOdometerReadings)
+	myRouter.HandleFunc("/getOdometerFlaggedCars", returnOdometerFlaggedCars)
+	myRouter.HandleFunc("/odometer", _recordOdometer).Methods("POST")
+	myRouter.HandleFunc("/getLiens/{id}", returnLiens)
+	myRouter.HandleFunc("/lien", _offerLien).Methods("POST")
+	myRouter.HandleFunc("/lien/accept", _acceptLien).Methods("POST")
+	myRouter.HandleFunc("/lien/decline", _declineLien).Methods("POST")
+	myRouter.HandleFunc("/lien/release", _releaseLien).Methods("POST")
+	myRouter.HandleFunc("/warranty/policy/{manufacturerId}/{carModel}", returnWarrantyPolicy)
+	myRouter.HandleFunc("/warranty/policy", _setWarrantyPolicy).Methods("POST")
+	myRouter.HandleFunc("/getWarranty/{id}", returnWarranty)
+	myRouter.HandleFunc("/getWarrantyClaims/{id}", returnWarrantyClaims)
+	myRouter.HandleFunc("/warranty/claim", _fileWarrantyClaim).Methods("POST")
+	myRouter.HandleFunc("/warranty/claim/approve", _approveWarrantyClaim).Methods("POST")
+	myRouter.HandleFunc("/warranty/claim/reject", _rejectWarrantyClaim).Methods("POST")
+	myRouter.HandleFunc("/decodeVin/{vin}", returnDecodedVin)
+	myRouter.HandleFunc("/participants", _registerParticipant).Methods("POST")
+	myRouter.HandleFunc("/participants/{type}", returnParticipants).Methods("GET")
+	myRouter.HandleFunc("/participants/{type}/{id}", returnParticipant).Methods("GET")
+	myRouter.HandleFunc("/participants/{type}/{id}", _updateParticipant).Methods("PUT")
+	myRouter.HandleFunc("/participants/{type}/{id}", _deactivateParticipant).Methods("DELETE")
+	myRouter.HandleFunc("/events", streamCarEvents)
+	myRouter.HandleFunc("/ws/events", websocketCarEvents)
 	log.Fatal(http.ListenAndServe(":10000", myRouter))
 }
 
 func main() {
+	go listenForCarEvents(carEvents)
 	handleRequests()
 }
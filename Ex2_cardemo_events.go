@@ -0,0 +1,193 @@
+/*
+Copyright 2022 IBM All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// carEventFilter matches every Car event name emitted by the chaincode
+const carEventFilter = "^Car[A-Za-z]+$"
+
+// listenerRetryDelay is how long the listener waits before reconnecting to the gateway
+const listenerRetryDelay = 5 * time.Second
+
+// subscriberBuffer is how many events a slow HTTP client may fall behind before events are dropped for it
+const subscriberBuffer = 64
+
+// CarEvent is the payload of a chaincode life cycle event, mirroring the chaincode's CarEvent
+type CarEvent struct {
+	EventName      string   `json:"eventName"`
+	Key            string   `json:"key"`
+	CarId          string   `json:"carId"`
+	PreviousStatus string   `json:"previousStatus"`
+	Status         string   `json:"status"`
+	TxId           string   `json:"txId"`
+	Car            *Car     `json:"car"`
+	CarIds         []string `json:"carIds,omitempty"`
+	BlockNumber    uint64   `json:"blockNumber"`
+}
+
+// carEventHub fans chaincode events out to every connected HTTP client
+type carEventHub struct {
+	mu          sync.Mutex
+	subscribers map[chan CarEvent]struct{}
+}
+
+// carEvents is the hub shared by the gateway listener and the streaming endpoints
+var carEvents = &carEventHub{subscribers: map[chan CarEvent]struct{}{}}
+
+func (h *carEventHub) subscribe() chan CarEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	subscriber := make(chan CarEvent, subscriberBuffer)
+	h.subscribers[subscriber] = struct{}{}
+
+	return subscriber
+}
+
+func (h *carEventHub) unsubscribe(subscriber chan CarEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.subscribers, subscriber)
+}
+
+// broadcast hands the event to every subscriber without waiting on slow ones
+func (h *carEventHub) broadcast(event CarEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for subscriber := range h.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			log.Printf("Dropping %s event for %s on a slow subscriber", event.EventName, event.CarId)
+		}
+	}
+}
+
+// listenForCarEvents registers a contract event listener and broadcasts what it receives, reconnecting when the stream ends
+func listenForCarEvents(hub *carEventHub) {
+	for {
+		if err := receiveCarEvents(hub); err != nil {
+			log.Printf("Car event listener stopped: %s", err)
+		}
+		time.Sleep(listenerRetryDelay)
+	}
+}
+
+// receiveCarEvents listens as the identity named by CARDEMO_IDENTITY
+func receiveCarEvents(hub *carEventHub) error {
+	gw, err := newGateway(os.Getenv(identityEnv))
+	if err != nil {
+		return err
+	}
+	defer gw.Close()
+
+	network, err := gw.GetNetwork("mychannel")
+	if err != nil {
+		return fmt.Errorf("Failed to get network: %s", err)
+	}
+
+	contract := network.GetContract("cardemo")
+
+	registration, notifier, err := contract.RegisterEvent(carEventFilter)
+	if err != nil {
+		return fmt.Errorf("Failed to register contract event: %s", err)
+	}
+	defer contract.Unregister(registration)
+
+	for ccEvent := range notifier {
+		var event CarEvent
+		if err := json.Unmarshal(ccEvent.Payload, &event); err != nil {
+			log.Printf("Failed to decode %s event in tx %s: %s", ccEvent.EventName, ccEvent.TxID, err)
+			continue
+		}
+		event.BlockNumber = ccEvent.BlockNumber
+		hub.broadcast(event)
+	}
+
+	return fmt.Errorf("event stream closed")
+}
+
+// streamCarEvents sends life cycle events to the client as Server-Sent Events
+func streamCarEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	flusher.Flush()
+
+	subscriber := carEvents.subscribe()
+	defer carEvents.unsubscribe(subscriber)
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-subscriber:
+			eventAsBytes, _ := json.Marshal(event)
+			fmt.Fprintf(w, "event: %s\nid: %s\ndata: %s\n\n", event.EventName, event.TxId, eventAsBytes)
+			flusher.Flush()
+		}
+	}
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// websocketCarEvents sends life cycle events to the client as WebSocket JSON messages
+func websocketCarEvents(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Failed to upgrade to WebSocket: %s", err)
+		return
+	}
+	defer conn.Close()
+
+	subscriber := carEvents.subscribe()
+	defer carEvents.unsubscribe(subscriber)
+
+	// the client sends nothing; reading only detects when it goes away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case event := <-subscriber:
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -7,17 +7,30 @@ SPDX-License-Identifier: Apache-2.0
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/hyperledger/fabric-sdk-go/pkg/core/config"
 	"github.com/hyperledger/fabric-sdk-go/pkg/gateway"
 )
 
+// Identities the sample submits as, each enrolled with role and participantId attributes as the README describes
+const (
+	manufacturerIdentity = "MOrg03@org1.example.com"
+	dealerIdentity       = "D101@org2.example.com"
+	consumerIdentity     = "CUST103@org3.example.com"
+	org1AdminIdentity    = "cardemo-admin@org1.example.com"
+	org2AdminIdentity    = "cardemo-admin@org2.example.com"
+)
+
 func main() {
 	os.Setenv("DISCOVERY_AS_LOCALHOST", "true")
 	wallet, err := gateway.NewFileSystemWallet("wallet")
@@ -26,66 +39,61 @@ func main() {
 		os.Exit(1)
 	}
 
-	if !wallet.Exists("CarDemoappUser") {
-		err = populateWallet(wallet)
+	contracts := map[string]*gateway.Contract{}
+	for _, label := range []string{manufacturerIdentity, dealerIdentity, consumerIdentity, org1AdminIdentity, org2AdminIdentity} {
+		gw, contract, err := connect(wallet, label)
 		if err != nil {
-			fmt.Printf("Failed to populate CarDemoappUser wallet contents: %s\n", err)
+			fmt.Printf("Failed to connect as %s: %s\n", label, err)
 			os.Exit(1)
 		}
+		defer gw.Close()
+		contracts[label] = contract
 	}
+	manufacturer := contracts[manufacturerIdentity]
+	dealer := contracts[dealerIdentity]
+	consumer := contracts[consumerIdentity]
 
-	ccpPath := filepath.Join(
-		"..",
-		"..",
-		"test-network",
-		"organizations",
-		"peerOrganizations",
-		"org1.example.com",
-		"connection-org1.yaml",
-	)
-
-	gw, err := gateway.Connect(
-		gateway.WithConfig(config.FromFile(filepath.Clean(ccpPath))),
-		gateway.WithIdentity(wallet, "CarDemoappUser"),
-	)
+	result, err := manufacturer.EvaluateTransaction("QueryAllCars", "false")
 	if err != nil {
-		fmt.Printf("Failed to connect to gateway: %s\n", err)
+		fmt.Printf("Failed to evaluate transaction: %s\n", err)
 		os.Exit(1)
 	}
-	defer gw.Close()
-
-	network, err := gw.GetNetwork("mychannel")
+	fmt.Println(string(result))
+	// Call CreateCar Function and supply paramters like input CarInput; manufacturerPrice goes in the transient map
+	input := fmt.Sprintf(`{"manufacturerId":"MOrg03","carId":"M105","carMake":"2022","carModel":"MOrg03CM101","carColor":"White","manufacturingDate":"%s"}`, time.Now().UTC().Format(time.RFC3339))
+	result, err = submitWithPrices(manufacturer, `{"manufacturerPrice":450000}`, "CreateCar", input)
 	if err != nil {
-		fmt.Printf("Failed to get network: %s\n", err)
+		fmt.Printf("Failed to submit  CreateCar transaction: %s\n", err)
 		os.Exit(1)
 	}
+	fmt.Println(string(result))
 
-	contract := network.GetContract("cardemo")
-
-	result, err := contract.EvaluateTransaction("QueryAllCars")
+	// Call QueryCar Function and by supplying CarID paramter
+	result, err = manufacturer.EvaluateTransaction("QueryCar", "M105")
 	if err != nil {
-		fmt.Printf("Failed to evaluate transaction: %s\n", err)
+		fmt.Printf("Failed to evaluate QueryCar transaction: %s\n", err)
 		os.Exit(1)
 	}
 	fmt.Println(string(result))
-	// Call createNewCar Function and supply paramters like manufacturerId string, carId string, carMake string, carModel string, carColor string, manufacturingDate string, manufacturerPrice int, role string
-	result, err = contract.SubmitTransaction("createNewCar", "MOrg03", "M105", "2022", "MOrg03CM101", "White", time.Now().String(), "450000", "manufacturer")
+
+	// Call Mint Function and supply paramters like ownerType string, ownerId string, amount int; the salt goes in the transient map
+	result, err = submitWithSalt(contracts[org1AdminIdentity], "Mint", "dealer", "D101", "462000")
 	if err != nil {
-		fmt.Printf("Failed to submit  createNewCar transaction: %s\n", err)
+		fmt.Printf("Failed to submit Mint transaction: %s\n", err)
 		os.Exit(1)
 	}
 	fmt.Println(string(result))
 
-	// Call QueryCar Function and by supplying CarID paramter
-	result, err = contract.EvaluateTransaction("QueryCar", "M105")
+	// Call Approve Function as the dealer and supply paramters like spenderType string, spenderId string, amount int; the salt goes in the transient map
+	result, err = submitWithSalt(dealer, "Approve", "manufacturer", "MOrg03", "462000")
 	if err != nil {
-		fmt.Printf("Failed to evaluate QueryCar transaction: %s\n", err)
+		fmt.Printf("Failed to submit Approve transaction: %s\n", err)
 		os.Exit(1)
 	}
 	fmt.Println(string(result))
 
-	// Call ShipToDealer Function and supply paramters like carId string, dealerId string, shippingPrice int, role string
-	result, err = contract.SubmitTransaction("ShipToDealer", "M105", "D101", "12000", "manufacturer")
+	// Call ShipToDealer Function and supply paramters like carId string, dealerId string, carrierId string, odometer int; shippingPrice goes in the transient map
+	result, err = submitWithPrices(manufacturer, `{"shippingPrice":12000}`, "ShipToDealer", "M105", "D101", "CARRIER1", "12")
 	if err != nil {
 		fmt.Printf("Failed to submit ShipToDealer transaction: %s\n", err)
 		os.Exit(1)
@@ -93,15 +101,15 @@ func main() {
 	fmt.Println(string(result))
 
 	// Call QueryCar Function and by supplying CarID paramter
-	result, err = contract.EvaluateTransaction("QueryCar", "M105")
+	result, err = manufacturer.EvaluateTransaction("QueryCar", "M105")
 	if err != nil {
 		fmt.Printf("Failed to evaluate QueryCar transaction: %s\n", err)
 		os.Exit(1)
 	}
 	fmt.Println(string(result))
 
-	// Call ReceiveDelivery Function and supply paramters like carId string, role string
-	result, err = contract.SubmitTransaction("ReceiveDelivery", "M105", "dealer")
+	// Call ReceiveDelivery Function and supply paramters like carId string, odometer int
+	result, err = dealer.SubmitTransaction("ReceiveDelivery", "M105", "85")
 	if err != nil {
 		fmt.Printf("Failed to submit ReceiveDelivery transaction: %s\n", err)
 		os.Exit(1)
@@ -109,15 +117,31 @@ func main() {
 	fmt.Println(string(result))
 
 	// Call QueryCar Function and by supplying CarID paramter
-	result, err = contract.EvaluateTransaction("QueryCar", "M105")
+	result, err = manufacturer.EvaluateTransaction("QueryCar", "M105")
 	if err != nil {
 		fmt.Printf("Failed to evaluate QueryCar transaction: %s\n", err)
 		os.Exit(1)
 	}
 	fmt.Println(string(result))
 
-	// Call SellToCustomer Function and supply paramters like carId string, consumerId string, customerPrice int, role string
-	result, err = contract.SubmitTransaction("SellToCustomer", "M105", "CUST103", "950000", "dealer")
+	// Call Mint Function and supply paramters like ownerType string, ownerId string, amount int; the salt goes in the transient map
+	result, err = submitWithSalt(contracts[org2AdminIdentity], "Mint", "consumer", "CUST103", "950000")
+	if err != nil {
+		fmt.Printf("Failed to submit Mint transaction: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(string(result))
+
+	// Call Approve Function as the consumer and supply paramters like spenderType string, spenderId string, amount int; the salt goes in the transient map
+	result, err = submitWithSalt(consumer, "Approve", "dealer", "D101", "950000")
+	if err != nil {
+		fmt.Printf("Failed to submit Approve transaction: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(string(result))
+
+	// Call SellToCustomer Function and supply paramters like carId string, consumerId string, odometer int; customerPrice goes in the transient map
+	result, err = submitWithPrices(dealer, `{"customerPrice":950000}`, "SellToCustomer", "M105", "CUST103", "91")
 	if err != nil {
 		fmt.Printf("Failed to submit SellToCustomer transaction: %s\n", err)
 		os.Exit(1)
@@ -125,7 +149,7 @@ func main() {
 	fmt.Println(string(result))
 
 	// Call QueryCar Function and by supplying CarID paramter
-	result, err = contract.EvaluateTransaction("QueryCar", "M105")
+	result, err = manufacturer.EvaluateTransaction("QueryCar", "M105")
 	if err != nil {
 		fmt.Printf("Failed to evaluate QueryCar transaction: %s\n", err)
 		os.Exit(1)
@@ -134,16 +158,103 @@ func main() {
 
 }
 
-func populateWallet(wallet *gateway.Wallet) error {
+// submitWithPrices submits the transaction with the given prices JSON, salted, in its transient map
+func submitWithPrices(contract *gateway.Contract, prices string, name string, args ...string) ([]byte, error) {
+	var transient map[string]interface{}
+	if err := json.Unmarshal([]byte(prices), &transient); err != nil {
+		return nil, err
+	}
+	salt, err := newSalt()
+	if err != nil {
+		return nil, err
+	}
+	transient["salt"] = salt
+	pricesAsBytes, _ := json.Marshal(transient)
+
+	txn, err := contract.CreateTransaction(name, gateway.WithTransient(map[string][]byte{"prices": pricesAsBytes}))
+	if err != nil {
+		return nil, err
+	}
+
+	return txn.Submit(args...)
+}
+
+// submitWithSalt submits the token transaction with a random salt for the private records it creates in its transient map
+func submitWithSalt(contract *gateway.Contract, name string, args ...string) ([]byte, error) {
+	salt, err := newSalt()
+	if err != nil {
+		return nil, err
+	}
+
+	txn, err := contract.CreateTransaction(name, gateway.WithTransient(map[string][]byte{"salt": []byte(salt)}))
+	if err != nil {
+		return nil, err
+	}
+
+	return txn.Submit(args...)
+}
+
+// newSalt returns a random hex salt that cannot be guessed to brute force the hashes of the private records
+func newSalt() (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(salt), nil
+}
+
+// connect opens a gateway to the test network as the enrolled identity, a label of the form <user>@<org domain>,
+// populating the wallet on first use
+func connect(wallet *gateway.Wallet, label string) (*gateway.Gateway, *gateway.Contract, error) {
+	if !wallet.Exists(label) {
+		if err := populateWallet(wallet, label); err != nil {
+			return nil, nil, fmt.Errorf("Failed to populate %s wallet contents: %s", label, err)
+		}
+	}
+
+	orgDomain := strings.SplitN(label, "@", 2)[1]
+	org := strings.SplitN(orgDomain, ".", 2)[0]
+	ccpPath := filepath.Join(
+		"..",
+		"..",
+		"test-network",
+		"organizations",
+		"peerOrganizations",
+		orgDomain,
+		"connection-"+org+".yaml",
+	)
+
+	gw, err := gateway.Connect(
+		gateway.WithConfig(config.FromFile(filepath.Clean(ccpPath))),
+		gateway.WithIdentity(wallet, label),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	network, err := gw.GetNetwork("mychannel")
+	if err != nil {
+		gw.Close()
+		return nil, nil, err
+	}
+
+	return gw, network.GetContract("cardemo"), nil
+}
+
+// populateWallet adds the identity enrolled under its organization's users directory to the wallet
+func populateWallet(wallet *gateway.Wallet, label string) error {
+	orgDomain := strings.SplitN(label, "@", 2)[1]
+	org := strings.SplitN(orgDomain, ".", 2)[0]
 	credPath := filepath.Join(
 		"..",
 		"..",
 		"test-network",
 		"organizations",
 		"peerOrganizations",
-		"org1.example.com",
+		orgDomain,
 		"users",
-		"User1@org1.example.com",
+		label,
 		"msp",
 	)
 
@@ -169,9 +280,10 @@ func populateWallet(wallet *gateway.Wallet) error {
 		return err
 	}
 
-	identity := gateway.NewX509Identity("Org1MSP", string(cert), string(key))
+	// the test network names the MSP of org1.example.com Org1MSP
+	identity := gateway.NewX509Identity(strings.ToUpper(org[:1])+org[1:]+"MSP", string(cert), string(key))
 
-	err = wallet.Put("CarDemoappUser", identity)
+	err = wallet.Put(label, identity)
 	if err != nil {
 		return err
 	}